@@ -3,7 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
 
 	"gopkg.in/h2non/bimg.v1"
@@ -30,14 +34,17 @@ func healthController(w http.ResponseWriter, r *http.Request) {
 
 func imageController(o ServerOptions, operation Operation) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
-		var imageSource = MatchSource(req)
-		if imageSource == nil {
-			ErrorReply(req, w, ErrMissingImageSource, o)
-			return
+		var buf []byte
+		imageSource, err := MatchSource(req)
+		if err == nil {
+			buf, err = imageSource.GetImage(req)
 		}
-
-		buf, err := imageSource.GetImage(req)
 		if err != nil {
+			// Image sources may reply with a specific error code, such as when the image is not found
+			if xerr, ok := err.(Error); ok {
+				ErrorReply(req, w, xerr, o)
+				return
+			}
 			ErrorReply(req, w, NewError(err.Error(), BadRequest), o)
 			return
 		}
@@ -51,6 +58,87 @@ func imageController(o ServerOptions, operation Operation) func(http.ResponseWri
 	}
 }
 
+// pipelineMaxBodySize is the maximum size of the JSON operations body of pipeline requests
+const pipelineMaxBodySize = 1 << 20
+
+// pipelineController serves the pipeline operation, which also accepts the operations as JSON body,
+// reading the image from the url, file or bucket params as GET requests do
+func pipelineController(o ServerOptions) func(http.ResponseWriter, *http.Request) {
+	controller := imageController(o, Pipeline)
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+			controller(w, req)
+			return
+		}
+
+		body, err := readLimitedBody(req.Body, pipelineMaxBodySize)
+		if err != nil {
+			ErrorReply(req, w, NewError("Cannot read the pipeline operations: "+err.Error(), BadRequest), o)
+			return
+		}
+
+		query := req.URL.Query()
+		query.Set("operations", string(body))
+
+		get := *req
+		get.Method = "GET"
+		get.URL = &url.URL{Path: req.URL.Path, RawQuery: query.Encode()}
+		controller(w, &get)
+	}
+}
+
+func sheetController(o ServerOptions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		opts, errs := readParams(req.URL.Query())
+		if o.StrictParams {
+			if errs := validateOperationParams(req.URL.Query(), "sheet", errs); len(errs) > 0 {
+				ErrorReply(req, w, NewValidationError(errs), o)
+				return
+			}
+		}
+
+		images, err := readSheetImages(req, o)
+		if xerr, ok := err.(Error); ok {
+			ErrorReply(req, w, xerr, o)
+			return
+		}
+		if err != nil {
+			ErrorReply(req, w, NewError("Cannot load images: "+err.Error(), BadRequest), o)
+			return
+		}
+
+		for _, image := range images {
+			if err := CheckInputLimits(image.Buf, o); err != nil {
+				ErrorReply(req, w, err.(Error), o)
+				return
+			}
+		}
+
+		if opts.Type != "" && (ImageType(opts.Type) == bimg.UNKNOWN || !IsImageTypeSupportedSave(opts.Type)) {
+			ErrorReply(req, w, ErrOutputFormat, o)
+			return
+		}
+
+		req.ParseForm()
+		opts.Captions = req.Form[sheetCaptionField]
+		opts.MaxOutputDimension = o.MaxOutputDimension
+
+		image, err := ContactSheet(images, opts)
+		if xerr, ok := err.(Error); ok && xerr.Code == Unprocessable {
+			ErrorReply(req, w, xerr, o)
+			return
+		}
+		if err != nil {
+			ErrorReply(req, w, NewError("Error while processing the image: "+err.Error(), BadRequest), o)
+			return
+		}
+
+		w.Header().Set("Content-Type", image.Mime)
+		w.Write(image.Body)
+	}
+}
+
 func imageHandler(w http.ResponseWriter, r *http.Request, buf []byte, Operation Operation, o ServerOptions) {
 	// Infer the body MIME type via mimesniff algorithm
 	mimeType := http.DetectContentType(buf)
@@ -63,6 +151,13 @@ func imageHandler(w http.ResponseWriter, r *http.Request, buf []byte, Operation
 		}
 	}
 
+	// Infer modern formats not known by mimesniff, such as AVIF, HEIF or JPEG XL, via libvips
+	if mimeType == "application/octet-stream" {
+		if mime := GetImageMimeType(DetermineImageType(buf)); mime != "" {
+			mimeType = mime
+		}
+	}
+
 	// Infer text/plain responses as potential SVG image
 	if strings.Contains(mimeType, "text/plain") && len(buf) > 8 {
 		if bimg.IsSVGImage(buf) {
@@ -76,18 +171,176 @@ func imageHandler(w http.ResponseWriter, r *http.Request, buf []byte, Operation
 		return
 	}
 
-	opts := readParams(r.URL.Query())
-	if opts.Type != "" && ImageType(opts.Type) == 0 {
+	// Check the image dimensions before processing it
+	if err := CheckInputLimits(buf, o); err != nil {
+		ErrorReply(r, w, err.(Error), o)
+		return
+	}
+
+	name := path.Base(r.URL.Path)
+	query := r.URL.Query()
+
+	// Pipeline operations may be also defined as multipart form field
+	if query.Get("operations") == "" && r.MultipartForm != nil {
+		query.Set("operations", r.FormValue("operations"))
+	}
+
+	// Reject invalid, unknown or missing params, if required by the server
+	opts, errs := readParams(query)
+	if o.StrictParams {
+		if errs := validateOperationParams(query, name, errs); len(errs) > 0 {
+			ErrorReply(r, w, NewValidationError(errs), o)
+			return
+		}
+	}
+
+	// Invalid pipeline operations are always rejected, describing the JSON error
+	for _, err := range errs {
+		if err.Param == "operations" {
+			ErrorReply(r, w, NewValidationError([]ParamError{err}), o)
+			return
+		}
+	}
+	opts.RedactGPS = o.RedactGPSMetadata
+
+	// Scale the pixel params by the device pixel ratio, limited by the server
+	if opts.DPR > 0 {
+		if opts.DPR < 1 {
+			ErrorReply(r, w, NewError(fmt.Sprintf("Invalid param: dpr must be between 1 and %d", maxDPR), BadRequest), o)
+			return
+		}
+		opts.DPR = math.Min(opts.DPR, maxServerDPR(o))
+		opts = ApplyDPR(opts, opts.DPR)
+	}
+
+	// JPEG XL images are losslessly decoded via libvips, since bimg doesn't support them,
+	// preserving the JPEG XL output type, unless another one is requested
+	if DetermineImageType(buf) == JXL {
+		if opts.Type == "" {
+			opts.Type = "jxl"
+		}
+
+		decoded, err := decodeImage(buf)
+		if err != nil {
+			ErrorReply(r, w, NewError("Cannot decode image: "+err.Error(), BadRequest), o)
+			return
+		}
+		buf = decoded
+	}
+
+	// Select the pages of multi-page images or rasterize vector images at the given density,
+	// preserving the original image type, if possible
+	if opts.Page > 0 || opts.Pages > 0 || opts.Density > 0 {
+		if name := bimg.DetermineImageTypeName(buf); opts.Type == "" && IsImageTypeSupportedSave(name) {
+			opts.Type = name
+		}
+
+		if err := CheckPagesLimits(buf, opts, o); err != nil {
+			ErrorReply(r, w, err.(Error), o)
+			return
+		}
+
+		pages, err := LoadImagePages(buf, opts)
+		if err != nil {
+			ErrorReply(r, w, NewError("Cannot load image pages: "+err.Error(), BadRequest), o)
+			return
+		}
+		buf = pages
+	}
+
+	// Resolve the dimensions relative to the source image size
+	opts, err := resolveRelativeParams(buf, opts)
+	if err != nil {
+		ErrorReply(r, w, NewError(err.Error(), BadRequest), o)
+		return
+	}
+
+	if err := CheckOutputLimits(opts.Width, opts.Height, o.MaxOutputDimension); err != nil {
+		ErrorReply(r, w, err.(Error), o)
+		return
+	}
+	if err := CheckOperationLimits(name, buf, opts, o.MaxOutputDimension); err != nil {
+		ErrorReply(r, w, err.(Error), o)
+		return
+	}
+	opts.MaxOutputDimension = o.MaxOutputDimension
+
+	// Negotiate the output image type based on the client Accept header
+	if opts.Type == "auto" {
+		w.Header().Add("Vary", "Accept")
+		opts.Type = AutoImageType(r.Header.Get("Accept"), buf)
+	}
+
+	if opts.Type != "" && (ImageType(opts.Type) == bimg.UNKNOWN || !IsImageTypeSupportedSave(opts.Type)) {
 		ErrorReply(r, w, ErrOutputFormat, o)
 		return
 	}
 
-	image, err := Operation.Run(buf, opts)
+	// The rest of operations, such as srcset or pipeline, check the encoder params of each output image
+	if _, ok := OperationsMap[name]; ok {
+		if err := CheckEncoderParams(opts, outputImageType(buf, opts)); err != nil {
+			ErrorReply(r, w, err.(Error), o)
+			return
+		}
+	}
+
+	// Load the watermark image, if present
+	if opts.Image != "" || hasImageField(r, watermarkImageField) {
+		watermark, err := readReferenceImage(r, watermarkImageField, opts.Image, o)
+		if err != nil {
+			ErrorReply(r, w, NewError("Cannot load watermark image: "+err.Error(), BadRequest), o)
+			return
+		}
+		if err := CheckInputLimits(watermark, o); err != nil {
+			ErrorReply(r, w, err.(Error), o)
+			return
+		}
+		opts.WatermarkBuffer = watermark
+	}
+
+	// Load the image to compare with, if present
+	if opts.Compare != "" || hasImageField(r, compareImageField) {
+		compare, err := readReferenceImage(r, compareImageField, opts.Compare, o)
+		if err != nil {
+			ErrorReply(r, w, NewError("Cannot load image to compare: "+err.Error(), BadRequest), o)
+			return
+		}
+		if err := CheckInputLimits(compare, o); err != nil {
+			ErrorReply(r, w, err.(Error), o)
+			return
+		}
+		opts.CompareBuffer = compare
+	}
+
+	image, err := runOperation(name, Operation, buf, opts)
+	if xerr, ok := err.(Error); ok && xerr.Code == Unprocessable {
+		ErrorReply(r, w, xerr, o)
+		return
+	}
 	if err != nil {
 		ErrorReply(r, w, NewError("Error while processing the image: "+err.Error(), BadRequest), o)
 		return
 	}
 
+	// Check the final image dimensions, since the output size of some operations is only estimated in advance
+	if o.MaxOutputDimension > 0 && strings.HasPrefix(image.Mime, "image/") {
+		if width, height, err := readImageSize(image.Body); err == nil {
+			if err := CheckOutputLimits(width, height, o.MaxOutputDimension); err != nil {
+				ErrorReply(r, w, err.(Error), o)
+				return
+			}
+		}
+	}
+
+	for key, value := range image.Headers {
+		w.Header().Set(key, value)
+	}
+
+	// Client hints only apply to images, not to JSON responses, such as info or srcset ones
+	if opts.DPR > 0 && strings.HasPrefix(image.Mime, "image/") {
+		w.Header().Set("Content-DPR", strconv.FormatFloat(opts.DPR, 'f', -1, 64))
+	}
+
 	w.Header().Set("Content-Type", image.Mime)
 	w.Write(image.Body)
 }
@@ -111,7 +364,14 @@ func formController(w http.ResponseWriter, r *http.Request) {
 		{"Color space (black&white)", "resize", "width=400&height=300&colorspace=bw"},
 		{"Add watermark", "watermark", "textwidth=100&text=Hello&font=sans%2012&opacity=0.5&color=255,200,50"},
 		{"Convert format", "convert", "type=png"},
+		{"Gaussian blur", "blur", "sigma=15.0&minampl=0.2"},
+		{"Sharpen", "sharpen", "radius=2&flat=1&jagged=2"},
 		{"Image metadata", "info", ""},
+		{"Color palette", "palette", "colors=6"},
+		{"BlurHash placeholder", "lqip", "kind=blurhash&xcomponents=4&ycomponents=3"},
+		{"Perceptual hash", "hash", ""},
+		{"Srcset (zip)", "srcset", "widths=320,640,1024&types=jpeg,webp&output=zip"},
+		{"Pipeline (crop + convert)", "pipeline", "operations=%5B%7B%22operation%22%3A%22crop%22%2C%22params%22%3A%7B%22width%22%3A300%7D%7D%2C%7B%22operation%22%3A%22convert%22%2C%22params%22%3A%7B%22type%22%3A%22png%22%7D%7D%5D"},
 	}
 
 	html := "<html><body>"
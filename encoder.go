@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// encoderParams defines the image types supported by each encoder param
+var encoderParams = []struct {
+	Name  string
+	Types []bimg.ImageType
+	IsSet func(ImageOptions) bool
+}{
+	{"progressive", []bimg.ImageType{bimg.JPEG, bimg.PNG}, func(o ImageOptions) bool { return o.Progressive }},
+	{"lossless", []bimg.ImageType{bimg.WEBP, bimg.HEIF, bimg.AVIF, JXL}, func(o ImageOptions) bool { return o.Lossless }},
+	{"nearlossless", []bimg.ImageType{bimg.WEBP}, func(o ImageOptions) bool { return o.NearLossless }},
+	{"effort", []bimg.ImageType{bimg.WEBP, bimg.HEIF, bimg.AVIF, JXL}, func(o ImageOptions) bool { return o.Effort > 0 }},
+	{"palette", []bimg.ImageType{bimg.PNG}, func(o ImageOptions) bool { return o.Palette }},
+	{"palettecolors", []bimg.ImageType{bimg.PNG}, func(o ImageOptions) bool { return o.PaletteColors > 0 }},
+	{"subsampling", []bimg.ImageType{bimg.JPEG, bimg.HEIF, bimg.AVIF}, func(o ImageOptions) bool { return o.Subsampling != "" }},
+}
+
+// subsamplingModes maps the chroma subsampling param values to the libvips subsample modes
+var subsamplingModes = map[string]string{
+	"auto": "auto",
+	"420":  "on",
+	"444":  "off",
+}
+
+// outputImageType returns the image type the operation output is expected to be encoded with
+func outputImageType(buf []byte, o ImageOptions) bimg.ImageType {
+	if o.Type != "" {
+		return ImageType(o.Type)
+	}
+	if name := bimg.DetermineImageTypeName(buf); IsImageTypeSupportedSave(name) {
+		return ImageType(name)
+	}
+	return bimg.JPEG
+}
+
+// CheckEncoderParams verifies that the encoder params apply to the output image type
+func CheckEncoderParams(o ImageOptions, kind bimg.ImageType) error {
+	for _, param := range encoderParams {
+		if !param.IsSet(o) {
+			continue
+		}
+
+		supported := false
+		for _, paramKind := range param.Types {
+			supported = supported || paramKind == kind
+		}
+		if !supported {
+			return NewError(fmt.Sprintf("Invalid param: %s is not supported by %s images", param.Name, ImageTypeName(kind)), BadRequest)
+		}
+	}
+
+	if o.PaletteColors > 256 {
+		return NewError("Invalid param: palettecolors must be lower than 257", BadRequest)
+	}
+	if _, ok := subsamplingModes[o.Subsampling]; o.Subsampling != "" && !ok {
+		return NewError("Invalid param: subsampling must be one of: auto, 420, 444", BadRequest)
+	}
+
+	return nil
+}
+
+// mergeOutputOptions overrides the output params of the given options with the ones defined by
+// the step options, such as the type, quality or encoder params of a pipeline step
+func mergeOutputOptions(o, step ImageOptions) ImageOptions {
+	if step.Type != "" {
+		o.Type = step.Type
+	}
+	if step.Quality > 0 || step.AutoQuality {
+		o.Quality, o.AutoQuality = step.Quality, step.AutoQuality
+	}
+	if step.MaxBytes > 0 {
+		o.MaxBytes = step.MaxBytes
+	}
+	if step.Compression > 0 {
+		o.Compression = step.Compression
+	}
+	if step.Effort > 0 {
+		o.Effort = step.Effort
+	}
+	if step.PaletteColors > 0 {
+		o.PaletteColors = step.PaletteColors
+	}
+	if step.Subsampling != "" {
+		o.Subsampling = step.Subsampling
+	}
+
+	o.Progressive = o.Progressive || step.Progressive
+	o.Lossless = o.Lossless || step.Lossless
+	o.NearLossless = o.NearLossless || step.NearLossless
+	o.Palette = o.Palette || step.Palette
+	o.Strip = o.Strip || step.Strip
+	o.NoProfile = o.NoProfile || step.NoProfile
+	return o
+}
+
+// needsVipsEncoder reports whether the image type or the encoder params are not supported by bimg,
+// requiring the image to be encoded via libvips
+func needsVipsEncoder(o ImageOptions, kind bimg.ImageType) bool {
+	return kind == JXL || o.NearLossless || o.Subsampling != "" || o.PaletteColors > 0 || (o.Effort > 0 && kind == bimg.WEBP)
+}
+
+// EncodeImage encodes the image with the given type, quality and width, if any,
+// applying the encoder params via bimg, or via libvips if bimg doesn't support them.
+func EncodeImage(buf []byte, kind bimg.ImageType, quality, width int, o ImageOptions) (Image, error) {
+	opts := bimg.Options{
+		Type:          kind,
+		Quality:       quality,
+		Compression:   o.Compression,
+		Speed:         encoderSpeed(o.Effort),
+		Width:         width,
+		Interlace:     o.Progressive,
+		Lossless:      o.Lossless,
+		Palette:       o.Palette,
+		StripMetadata: o.Strip,
+		NoAutoRotate:  true,
+		NoProfile:     o.NoProfile,
+	}
+
+	if !needsVipsEncoder(o, kind) {
+		return Process(buf, opts)
+	}
+
+	format, err := vipsSaveFormat(kind, quality, o)
+	if err != nil {
+		return Image{}, err
+	}
+
+	// The image is resized, if required, and losslessly encoded before being encoded via libvips
+	if width > 0 || bimg.DetermineImageType(buf) != bimg.PNG {
+		source, err := Process(buf, bimg.Options{Type: bimg.PNG, Width: width, NoAutoRotate: true, NoProfile: o.NoProfile})
+		if err != nil {
+			return Image{}, err
+		}
+		buf = source.Body
+	}
+
+	body, err := vipsSaveBuffer(buf, format)
+	if err != nil {
+		return Image{}, err
+	}
+	return Image{Body: body, Mime: GetImageMimeType(kind)}, nil
+}
+
+// vipsSaveFormat returns the libvips format string, with the saver options, for the given
+// image type and encoder params, such as .webp[Q=80,near_lossless=true]
+func vipsSaveFormat(kind bimg.ImageType, quality int, o ImageOptions) (string, error) {
+	suffixes := map[bimg.ImageType]string{
+		bimg.JPEG: ".jpg",
+		bimg.PNG:  ".png",
+		bimg.WEBP: ".webp",
+		bimg.HEIF: ".heif",
+		bimg.AVIF: ".avif",
+		JXL:       ".jxl",
+	}
+
+	suffix, ok := suffixes[kind]
+	if !ok {
+		return "", NewError("Unsupported encoder params for "+ImageTypeName(kind)+" images", BadRequest)
+	}
+
+	options := []string{}
+	if quality > 0 && kind != bimg.PNG {
+		options = append(options, fmt.Sprintf("Q=%d", quality))
+	}
+	if o.Compression > 0 && kind == bimg.PNG {
+		options = append(options, fmt.Sprintf("compression=%d", o.Compression))
+	}
+	if o.Progressive {
+		options = append(options, "interlace=true")
+	}
+	if o.Lossless {
+		options = append(options, "lossless=true")
+	}
+	if o.NearLossless {
+		options = append(options, "near_lossless=true")
+	}
+	if o.Effort > 0 && kind == bimg.WEBP {
+		options = append(options, fmt.Sprintf("reduction_effort=%d", webpEffort(o.Effort)))
+	}
+	if o.Effort > 0 && (kind == bimg.HEIF || kind == bimg.AVIF) {
+		options = append(options, fmt.Sprintf("speed=%d", encoderSpeed(o.Effort)))
+	}
+	if o.Effort > 0 && kind == JXL {
+		options = append(options, fmt.Sprintf("effort=%d", o.Effort))
+	}
+	// The palette colors imply the palette quantization
+	if o.Palette || o.PaletteColors > 0 {
+		options = append(options, "palette=true")
+		if o.PaletteColors > 0 {
+			options = append(options, fmt.Sprintf("bitdepth=%d", paletteBitDepth(o.PaletteColors)))
+		}
+	}
+	if mode, ok := subsamplingModes[o.Subsampling]; ok {
+		options = append(options, "subsample_mode="+mode)
+	}
+	if o.Strip {
+		options = append(options, "strip=true")
+	}
+
+	if len(options) == 0 {
+		return suffix, nil
+	}
+	return suffix + "[" + strings.Join(options, ",") + "]", nil
+}
+
+// webpEffort maps the encoding effort, from 1 (fastest) to 9 (smallest output),
+// to the libvips WebP reduction effort, from 0 to 6
+func webpEffort(effort int) int {
+	if effort > 9 {
+		effort = 9
+	}
+	return int(math.Floor(float64(effort-1)*6/8 + 0.5))
+}
+
+// paletteBitDepth returns the lowest PNG palette bit depth fitting the given number of colors
+func paletteBitDepth(colors int) int {
+	for _, depth := range []int{1, 2, 4} {
+		if colors <= 1<<uint(depth) {
+			return depth
+		}
+	}
+	return 8
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+func TestCheckEncoderParams(t *testing.T) {
+	cases := []struct {
+		opts  ImageOptions
+		kind  bimg.ImageType
+		valid bool
+	}{
+		{ImageOptions{Progressive: true}, bimg.JPEG, true},
+		{ImageOptions{Progressive: true}, bimg.PNG, true},
+		{ImageOptions{Progressive: true}, bimg.WEBP, false},
+		{ImageOptions{Lossless: true, NearLossless: true, Effort: 6}, bimg.WEBP, true},
+		{ImageOptions{Lossless: true}, bimg.JPEG, false},
+		{ImageOptions{NearLossless: true}, bimg.AVIF, false},
+		{ImageOptions{Effort: 3}, bimg.JPEG, false},
+		{ImageOptions{Palette: true, PaletteColors: 64}, bimg.PNG, true},
+		{ImageOptions{PaletteColors: 300}, bimg.PNG, false},
+		{ImageOptions{PaletteColors: 16}, bimg.JPEG, false},
+		{ImageOptions{Palette: true}, bimg.GIF, false},
+		{ImageOptions{Subsampling: "444"}, bimg.JPEG, true},
+		{ImageOptions{Subsampling: "422"}, bimg.JPEG, false},
+		{ImageOptions{Subsampling: "420"}, bimg.PNG, false},
+		{ImageOptions{Strip: true}, bimg.GIF, true},
+		{ImageOptions{Colors: 6}, bimg.JPEG, true},
+	}
+
+	for _, test := range cases {
+		err := CheckEncoderParams(test.opts, test.kind)
+		if (err == nil) != test.valid {
+			t.Errorf("Invalid encoder params check for %#v: %v", test.opts, err)
+		}
+		if err != nil && err.(Error).Code != BadRequest {
+			t.Errorf("Invalid error code: %d", err.(Error).Code)
+		}
+	}
+}
+
+func TestNeedsVipsEncoder(t *testing.T) {
+	if needsVipsEncoder(ImageOptions{Progressive: true, Lossless: true, Palette: true, Strip: true}, bimg.PNG) {
+		t.Error("Encoder params supported by bimg must not require libvips encoder")
+	}
+	if needsVipsEncoder(ImageOptions{Effort: 5}, bimg.AVIF) {
+		t.Error("AVIF effort must not require libvips encoder")
+	}
+	if !needsVipsEncoder(ImageOptions{Effort: 5}, bimg.WEBP) {
+		t.Error("WebP effort must require libvips encoder")
+	}
+	if !needsVipsEncoder(ImageOptions{}, JXL) {
+		t.Error("JPEG XL must require libvips encoder")
+	}
+	if !needsVipsEncoder(ImageOptions{PaletteColors: 16}, bimg.PNG) {
+		t.Error("Palette colors must require libvips encoder")
+	}
+}
+
+func TestMergeOutputOptions(t *testing.T) {
+	o := mergeOutputOptions(ImageOptions{MaxBytes: 1000}, ImageOptions{Width: 300, Quality: 80, Progressive: true})
+	o = mergeOutputOptions(o, ImageOptions{Type: "png", Compression: 9})
+
+	if o.Width != 0 {
+		t.Errorf("Transformation params must not be merged: %#v", o)
+	}
+	if o.Type != "png" || o.Quality != 80 || o.Compression != 9 || !o.Progressive || o.MaxBytes != 1000 {
+		t.Errorf("Invalid output options: %#v", o)
+	}
+}
+
+func TestVipsSaveFormat(t *testing.T) {
+	cases := []struct {
+		kind     bimg.ImageType
+		quality  int
+		opts     ImageOptions
+		expected string
+	}{
+		{bimg.JPEG, 0, ImageOptions{}, ".jpg"},
+		{bimg.JPEG, 85, ImageOptions{Progressive: true, Subsampling: "444", Strip: true}, ".jpg[Q=85,interlace=true,subsample_mode=off,strip=true]"},
+		{bimg.WEBP, 90, ImageOptions{NearLossless: true, Effort: 9}, ".webp[Q=90,near_lossless=true,reduction_effort=6]"},
+		{bimg.PNG, 80, ImageOptions{Palette: true, PaletteColors: 16, Compression: 9}, ".png[compression=9,palette=true,bitdepth=4]"},
+		{bimg.PNG, 0, ImageOptions{PaletteColors: 2}, ".png[palette=true,bitdepth=1]"},
+		{bimg.AVIF, 50, ImageOptions{Lossless: true, Effort: 1}, ".avif[Q=50,lossless=true,speed=8]"},
+		{JXL, 75, ImageOptions{Effort: 7}, ".jxl[Q=75,effort=7]"},
+	}
+
+	for _, test := range cases {
+		format, err := vipsSaveFormat(test.kind, test.quality, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != test.expected {
+			t.Errorf("Invalid save format: %s != %s", format, test.expected)
+		}
+	}
+
+	if _, err := vipsSaveFormat(bimg.GIF, 0, ImageOptions{}); err == nil {
+		t.Error("Unsupported image type must fail")
+	}
+}
+
+func TestWebpEffort(t *testing.T) {
+	cases := []struct{ effort, expected int }{{1, 0}, {5, 3}, {9, 6}, {20, 6}}
+
+	for _, test := range cases {
+		if effort := webpEffort(test.effort); effort != test.expected {
+			t.Errorf("Invalid WebP effort for %d: %d", test.effort, effort)
+		}
+	}
+}
+
+func TestPaletteBitDepth(t *testing.T) {
+	cases := []struct{ colors, expected int }{{2, 1}, {4, 2}, {5, 4}, {16, 4}, {17, 8}, {256, 8}}
+
+	for _, test := range cases {
+		if depth := paletteBitDepth(test.colors); depth != test.expected {
+			t.Errorf("Invalid bit depth for %d colors: %d", test.colors, depth)
+		}
+	}
+}
+
+func TestImageProgressive(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	img, err := Resize(buf, ImageOptions{Width: 300, Progressive: true})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+
+	// Progressive JPEG images use the SOF2 marker
+	if !bytes.Contains(img.Body, []byte{0xFF, 0xC2}) {
+		t.Error("Image must be encoded as progressive JPEG")
+	}
+}
+
+func TestImagePaletteColors(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	img, err := runOperation("resize", Resize, buf, ImageOptions{Width: 300, Type: "png", Palette: true, PaletteColors: 16})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "image/png" {
+		t.Errorf("Invalid image MIME type: %s", img.Mime)
+	}
+
+	// PNG color type is stored in the IHDR chunk, being 3 for indexed colors
+	if len(img.Body) < 26 || img.Body[25] != 3 || img.Body[24] != 4 {
+		t.Error("Image must be encoded as 4 bit palette PNG")
+	}
+}
@@ -17,6 +17,8 @@ const (
 	Unauthorized
 	InternalError
 	NotFound
+	PayloadTooLarge
+	Unprocessable
 )
 
 var (
@@ -33,10 +35,25 @@ var (
 )
 
 type Error struct {
-	Message string `json:"message,omitempty"`
-	Code    uint8  `json:"code"`
+	Message string       `json:"message,omitempty"`
+	Code    uint8        `json:"code"`
+	Errors  []ParamError `json:"errors,omitempty"`
 }
 
+// ParamError describes an invalid, unknown or missing request param
+type ParamError struct {
+	Param   string `json:"param"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+// paramErrorsByName sorts the param errors by param name
+type paramErrorsByName []ParamError
+
+func (e paramErrorsByName) Len() int           { return len(e) }
+func (e paramErrorsByName) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e paramErrorsByName) Less(i, j int) bool { return e[i].Param < e[j].Param }
+
 func (e Error) JSON() []byte {
 	buf, _ := json.Marshal(e)
 	return buf
@@ -65,24 +82,39 @@ func (e Error) HTTPCode() int {
 	if e.Code == NotFound {
 		return http.StatusNotFound
 	}
+	if e.Code == PayloadTooLarge {
+		return http.StatusRequestEntityTooLarge
+	}
+	if e.Code == Unprocessable {
+		return http.StatusUnprocessableEntity
+	}
 	return http.StatusServiceUnavailable
 }
 
 func NewError(err string, code uint8) Error {
 	err = strings.Replace(err, "\n", "", -1)
-	return Error{err, code}
+	return Error{Message: err, Code: code}
+}
+
+// NewValidationError creates a new bad request error listing every invalid param
+func NewValidationError(errors []ParamError) Error {
+	err := NewError("Invalid params", BadRequest)
+	err.Errors = errors
+	return err
 }
 
 func replyWithPlaceholder(req *http.Request, w http.ResponseWriter, err Error, o ServerOptions) error {
 	image := o.PlaceholderImage
 
 	// Resize placeholder to expected output
+	width, _ := parseInt(req.URL.Query().Get("width"))
+	height, _ := parseInt(req.URL.Query().Get("height"))
 	buf, _err := bimg.Resize(o.PlaceholderImage, bimg.Options{
 		Force:   true,
 		Crop:    true,
 		Enlarge: true,
-		Width:   parseInt(req.URL.Query().Get("width")),
-		Height:  parseInt(req.URL.Query().Get("height")),
+		Width:   width,
+		Height:  height,
 		Type:    ImageType(req.URL.Query().Get("type")),
 	})
 
@@ -22,3 +22,33 @@ func TestError(t *testing.T) {
 		t.Fatalf("Invalid JSON output: %s", json)
 	}
 }
+
+func TestErrorHTTPCode(t *testing.T) {
+	cases := []struct {
+		code   uint8
+		status int
+	}{
+		{NotFound, 404},
+		{PayloadTooLarge, 413},
+		{Unprocessable, 422},
+	}
+
+	for _, test := range cases {
+		if status := NewError("oops!", test.code).HTTPCode(); status != test.status {
+			t.Errorf("Invalid HTTP error status for code %d: %d", test.code, status)
+		}
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := NewValidationError([]ParamError{{Param: "width", Value: "abc", Message: "must be an integer"}})
+
+	if err.HTTPCode() != 400 {
+		t.Fatalf("Invalid HTTP error status: %d", err.HTTPCode())
+	}
+
+	json := string(err.JSON())
+	if json != `{"message":"Invalid params","code":1,"errors":[{"param":"width","value":"abc","message":"must be an integer"}]}` {
+		t.Fatalf("Invalid JSON output: %s", json)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	compareImageField    = "compare"
+	hashSampleSize       = 128
+	hashDefaultAlgorithm = "phash"
+	hashDefaultThreshold = 10
+)
+
+// ImageHashes represents the perceptual hashes of an image as 64 bits hexadecimal strings
+type ImageHashes struct {
+	AHash string `json:"ahash"`
+	DHash string `json:"dhash"`
+	PHash string `json:"phash"`
+}
+
+// ImageHashComparison represents the result of comparing two images by its perceptual hash
+type ImageHashComparison struct {
+	Hash      ImageHashes `json:"hash"`
+	Compare   ImageHashes `json:"compare"`
+	Algorithm string      `json:"algorithm"`
+	Distance  int         `json:"distance"`
+	Threshold int         `json:"threshold"`
+	Verdict   string      `json:"verdict"`
+}
+
+// GetImageHashes calculates the average, difference and perceptual hashes of the given image
+func GetImageHashes(img image.Image) ImageHashes {
+	return ImageHashes{
+		AHash: formatHash(AverageHash(img)),
+		DHash: formatHash(DifferenceHash(img)),
+		PHash: formatHash(PerceptualHash(img)),
+	}
+}
+
+// CompareImageHashes compares two set of image hashes using the given algorithm.
+// Images are considered similar if the Hamming distance is lower or equal than the threshold.
+func CompareImageHashes(hash, compare ImageHashes, algorithm string, threshold int) (ImageHashComparison, error) {
+	comparison := ImageHashComparison{Hash: hash, Compare: compare, Algorithm: algorithm, Threshold: threshold}
+
+	var a, b string
+	switch algorithm {
+	case "ahash":
+		a, b = hash.AHash, compare.AHash
+	case "dhash":
+		a, b = hash.DHash, compare.DHash
+	case "phash":
+		a, b = hash.PHash, compare.PHash
+	default:
+		return comparison, fmt.Errorf("unsupported hash algorithm: %s", algorithm)
+	}
+
+	x, _ := strconv.ParseUint(a, 16, 64)
+	y, _ := strconv.ParseUint(b, 16, 64)
+	comparison.Distance = HammingDistance(x, y)
+
+	switch {
+	case comparison.Distance == 0:
+		comparison.Verdict = "identical"
+	case comparison.Distance <= threshold:
+		comparison.Verdict = "similar"
+	default:
+		comparison.Verdict = "different"
+	}
+
+	return comparison, nil
+}
+
+// AverageHash calculates the hash based on whether each pixel of the 8x8 grayscale
+// image is brighter than the average
+func AverageHash(img image.Image) uint64 {
+	pixels := grayscaleGrid(img, 8, 8)
+
+	mean := 0.0
+	for _, value := range pixels {
+		mean += value
+	}
+	mean /= float64(len(pixels))
+
+	var hash uint64
+	for i, value := range pixels {
+		if value > mean {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// DifferenceHash calculates the hash based on the horizontal brightness gradient
+// of the 9x8 grayscale image
+func DifferenceHash(img image.Image) uint64 {
+	pixels := grayscaleGrid(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if pixels[y*9+x] < pixels[y*9+x+1] {
+				hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return hash
+}
+
+// PerceptualHash calculates the hash based on the lowest frequencies of the discrete
+// cosine transform of the 32x32 grayscale image, compared against its median
+func PerceptualHash(img image.Image) uint64 {
+	const size, lowSize = 32, 8
+	pixels := grayscaleGrid(img, size, size)
+
+	coefficients := make([]float64, 0, lowSize*lowSize)
+	for v := 0; v < lowSize; v++ {
+		for u := 0; u < lowSize; u++ {
+			sum := 0.0
+			for y := 0; y < size; y++ {
+				fy := math.Cos(float64(2*y+1) * float64(v) * math.Pi / (2 * size))
+				for x := 0; x < size; x++ {
+					sum += pixels[y*size+x] * fy * math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*size))
+				}
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+
+	sorted := make([]float64, len(coefficients))
+	copy(sorted, coefficients)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, value := range coefficients {
+		if value > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// HammingDistance returns the number of different bits between two hashes
+func HammingDistance(a, b uint64) int {
+	distance := 0
+	for x := a ^ b; x != 0; x &= x - 1 {
+		distance++
+	}
+	return distance
+}
+
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// grayscaleGrid downscales the image to the given size, ignoring its aspect ratio,
+// by averaging the luma of the pixels covered by each cell
+func grayscaleGrid(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	pixels := make([]float64, width*height)
+
+	for ty := 0; ty < height; ty++ {
+		y0 := ty * sh / height
+		y1 := int(math.Max(float64(y0+1), float64((ty+1)*sh/height)))
+
+		for tx := 0; tx < width; tx++ {
+			x0 := tx * sw / width
+			x1 := int(math.Max(float64(x0+1), float64((tx+1)*sw/width)))
+
+			sum := 0.0
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+					sum += 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8)
+				}
+			}
+			pixels[ty*width+tx] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+
+	return pixels
+}
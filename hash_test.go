@@ -0,0 +1,114 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func gradientImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(x * 255 / width)
+			img.Set(x, y, color.NRGBA{v, v, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func TestAverageHash(t *testing.T) {
+	// Left half black, right half white
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			c := color.NRGBA{0, 0, 0, 255}
+			if x >= 8 {
+				c = color.NRGBA{255, 255, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	if hash := formatHash(AverageHash(img)); hash != "f0f0f0f0f0f0f0f0" {
+		t.Errorf("Invalid average hash: %s", hash)
+	}
+}
+
+func TestDifferenceHash(t *testing.T) {
+	if hash := formatHash(DifferenceHash(gradientImage(90, 80))); hash != "ffffffffffffffff" {
+		t.Errorf("Invalid difference hash: %s", hash)
+	}
+}
+
+func wavesImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			v := uint8(128 + 127*math.Sin(5*fx)*math.Cos(3*fy+fx))
+			img.Set(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	a := PerceptualHash(wavesImage(128, 96))
+	b := PerceptualHash(wavesImage(64, 48))
+
+	if distance := HammingDistance(a, b); distance > 4 {
+		t.Errorf("Scaled images must have a similar hash: %d", distance)
+	}
+	if a == 0 {
+		t.Error("Invalid empty perceptual hash")
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	cases := []struct {
+		a, b     uint64
+		distance int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0xff, 0x0f, 4},
+		{0, 0xffffffffffffffff, 64},
+	}
+
+	for _, test := range cases {
+		if distance := HammingDistance(test.a, test.b); distance != test.distance {
+			t.Errorf("Invalid distance for %x and %x: %d", test.a, test.b, distance)
+		}
+	}
+}
+
+func TestCompareImageHashes(t *testing.T) {
+	hash := ImageHashes{AHash: "00000000000000ff", DHash: "0000000000000000", PHash: "ffffffffffffffff"}
+	compare := ImageHashes{AHash: "00000000000000ff", DHash: "000000000000000f", PHash: "0000000000000000"}
+
+	cases := []struct {
+		algorithm string
+		distance  int
+		verdict   string
+	}{
+		{"ahash", 0, "identical"},
+		{"dhash", 4, "similar"},
+		{"phash", 64, "different"},
+	}
+
+	for _, test := range cases {
+		comparison, err := CompareImageHashes(hash, compare, test.algorithm, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if comparison.Distance != test.distance || comparison.Verdict != test.verdict {
+			t.Errorf("Invalid %s comparison: %#v", test.algorithm, comparison)
+		}
+	}
+
+	if _, err := CompareImageHashes(hash, compare, "foo", 10); err == nil {
+		t.Error("Unsupported algorithm must fail")
+	}
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"strings"
 
 	"gopkg.in/h2non/bimg.v1"
 )
 
-// Image stores an image binary buffer and its MIME type
+// Image stores an image binary buffer, its MIME type and optional response headers
 type Image struct {
-	Body []byte
-	Mime string
+	Body    []byte
+	Mime    string
+	Headers map[string]string
 }
 
 // Operation implements an image transformation runnable interface
@@ -31,6 +38,9 @@ type ImageInfo struct {
 	Profile     bool   `json:"hasProfile"`
 	Channels    int    `json:"channels"`
 	Orientation int    `json:"orientation"`
+	Pages       int    `json:"pages"`
+
+	Metadata *ImageMetadataInfo `json:"metadata,omitempty"`
 }
 
 func Info(buf []byte, o ImageOptions) (Image, error) {
@@ -52,6 +62,16 @@ func Info(buf []byte, o ImageOptions) (Image, error) {
 		Profile:     meta.Profile,
 		Channels:    meta.Channels,
 		Orientation: meta.Orientation,
+		Pages:       1,
+	}
+
+	if pages, err := vipsImagePages(buf); err == nil {
+		info.Pages = pages
+	}
+
+	if o.Metadata == "full" {
+		metadata := ReadMetadata(buf, o.RedactGPS)
+		info.Metadata = &metadata
 	}
 
 	body, _ := json.Marshal(info)
@@ -60,6 +80,199 @@ func Info(buf []byte, o ImageOptions) (Image, error) {
 	return image, nil
 }
 
+func Palette(buf []byte, o ImageOptions) (Image, error) {
+	image := Image{Mime: "application/json"}
+
+	colors := o.Colors
+	if colors == 0 {
+		colors = paletteDefaultColors
+	}
+	if colors < 1 || colors > paletteMaxColors {
+		return image, NewError(fmt.Sprintf("Invalid param: colors must be between 1 and %d", paletteMaxColors), BadRequest)
+	}
+
+	img, err := sampleImage(buf, paletteSampleSize, bimg.Options{NoAutoRotate: o.NoRotation})
+	if err != nil {
+		return image, err
+	}
+
+	body, _ := json.Marshal(GetImagePalette(img, colors))
+	image.Body = body
+
+	return image, nil
+}
+
+func LQIP(buf []byte, o ImageOptions) (Image, error) {
+	image := Image{Mime: "application/json"}
+
+	kind := parseLQIPKind(o.Kind)
+	xComponents, yComponents := o.XComponents, o.YComponents
+	if xComponents == 0 {
+		xComponents = 4
+	}
+	if yComponents == 0 {
+		yComponents = 3
+	}
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return image, NewError("Invalid param: xcomponents and ycomponents must be between 1 and 9", BadRequest)
+	}
+
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return image, NewError("Cannot retrieve image metadata: "+err.Error(), BadRequest)
+	}
+
+	preview := ImagePreview{Kind: kind}
+	preview.Width, preview.Height = orientedSize(meta, bimg.Options{NoAutoRotate: o.NoRotation})
+
+	switch kind {
+	case "blurhash":
+		img, err := sampleImage(buf, blurHashSampleSize, bimg.Options{NoAutoRotate: o.NoRotation})
+		if err != nil {
+			return image, err
+		}
+		preview.Value = BlurHash(img, xComponents, yComponents)
+	case "thumbhash":
+		img, err := sampleImage(buf, thumbHashSampleSize, bimg.Options{NoAutoRotate: o.NoRotation})
+		if err != nil {
+			return image, err
+		}
+		preview.Value = ThumbHash(img)
+	case "datauri":
+		opts := BimgOptions(o)
+		if opts.Width == 0 && opts.Height == 0 {
+			fitSize(meta, lqipDefaultSize, &opts)
+		}
+		tiny, err := Process(buf, opts)
+		if err != nil {
+			return image, err
+		}
+		preview.Value = DataURI(tiny.Body, tiny.Mime)
+	default:
+		return image, NewError("Invalid param: kind must be one of blurhash, thumbhash or datauri", BadRequest)
+	}
+
+	body, _ := json.Marshal(preview)
+	image.Body = body
+
+	return image, nil
+}
+
+func Hash(buf []byte, o ImageOptions) (Image, error) {
+	image := Image{Mime: "application/json"}
+
+	img, err := sampleImage(buf, hashSampleSize, bimg.Options{NoAutoRotate: o.NoRotation})
+	if err != nil {
+		return image, err
+	}
+	hashes := GetImageHashes(img)
+
+	if len(o.CompareBuffer) == 0 {
+		body, _ := json.Marshal(hashes)
+		image.Body = body
+		return image, nil
+	}
+
+	compareImg, err := sampleImage(o.CompareBuffer, hashSampleSize, bimg.Options{NoAutoRotate: o.NoRotation})
+	if err != nil {
+		return image, err
+	}
+
+	algorithm, threshold := o.Algorithm, hashDefaultThreshold
+	if algorithm == "" {
+		algorithm = hashDefaultAlgorithm
+	}
+	if o.Threshold != nil {
+		threshold = *o.Threshold
+	}
+
+	comparison, err := CompareImageHashes(hashes, GetImageHashes(compareImg), algorithm, threshold)
+	if err != nil {
+		return image, NewError("Invalid param: algorithm must be one of ahash, dhash or phash", BadRequest)
+	}
+
+	body, _ := json.Marshal(comparison)
+	image.Body = body
+
+	return image, nil
+}
+
+func Srcset(buf []byte, o ImageOptions) (Image, error) {
+	if len(o.Widths) == 0 {
+		return Image{}, NewError("Missing required param: widths", BadRequest)
+	}
+
+	types := o.Types
+	if len(types) == 0 && o.Type != "" {
+		types = []string{o.Type}
+	} else if len(types) == 0 {
+		types = []string{bimg.DetermineImageTypeName(buf)}
+	}
+	for _, name := range types {
+		if ImageType(name) == bimg.UNKNOWN || !IsImageTypeSupportedSave(name) {
+			return Image{}, NewError("Unsupported output image format: "+name, BadRequest)
+		}
+		if err := CheckEncoderParams(o, ImageType(name)); err != nil {
+			return Image{}, err
+		}
+	}
+
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return Image{}, NewError("Cannot retrieve image metadata: "+err.Error(), BadRequest)
+	}
+
+	opts := BimgOptions(o)
+	lossless := BimgOptions(losslessOptions(o))
+	width, _ := orientedSize(meta, opts)
+	widths := srcsetWidths(o.Widths, width)
+	if len(widths)*len(types) > srcsetMaxVariants {
+		return Image{}, NewError(fmt.Sprintf("Invalid param: up to %d variants are allowed", srcsetMaxVariants), BadRequest)
+	}
+
+	variants := []SrcsetVariant{}
+	for _, name := range types {
+		kind := ImageType(name)
+		for _, width := range widths {
+			var variant Image
+			if needsOutputEncoding(o, kind) {
+				lossless.Width, lossless.Height = width, 0
+				if variant, err = Process(buf, lossless); err == nil {
+					variant, err = EncodeOutput(variant, kind, o)
+				}
+			} else {
+				opts.Width, opts.Height = width, 0
+				opts.Type = kind
+				variant, err = Process(buf, opts)
+			}
+			if err != nil {
+				return Image{}, err
+			}
+
+			size, err := bimg.Size(variant.Body)
+			if err != nil {
+				return Image{}, NewError("Cannot retrieve image size: "+err.Error(), InternalError)
+			}
+
+			variants = append(variants, SrcsetVariant{
+				Width:  size.Width,
+				Height: size.Height,
+				Type:   imageTypeAlias(name),
+				Mime:   variant.Mime,
+				Size:   len(variant.Body),
+				Body:   variant.Body,
+			})
+		}
+	}
+
+	body, mime, err := EncodeSrcset(variants, o.Output)
+	if err != nil {
+		return Image{}, NewError("Invalid param: output must be one of json, multipart or zip", BadRequest)
+	}
+
+	return Image{Body: body, Mime: mime}, nil
+}
+
 func Resize(buf []byte, o ImageOptions) (Image, error) {
 	if o.Width == 0 && o.Height == 0 {
 		return Image{}, NewError("Missing required param: height or width", BadRequest)
@@ -70,6 +283,10 @@ func Resize(buf []byte, o ImageOptions) (Image, error) {
 
 	if o.NoCrop == false {
 		opts.Crop = true
+
+		if o.Gravity == bimg.GravitySmart && o.Width > 0 && o.Height > 0 {
+			return SmartCrop(buf, opts)
+		}
 	}
 
 	return Process(buf, opts)
@@ -111,6 +328,11 @@ func Crop(buf []byte, o ImageOptions) (Image, error) {
 
 	opts := BimgOptions(o)
 	opts.Crop = true
+
+	if o.Gravity == bimg.GravitySmart {
+		return SmartCrop(buf, opts)
+	}
+
 	return Process(buf, opts)
 }
 
@@ -119,8 +341,25 @@ func Rotate(buf []byte, o ImageOptions) (Image, error) {
 		return Image{}, NewError("Missing required param: rotate", BadRequest)
 	}
 
+	if isRightAngle(o.Rotate) {
+		opts := BimgOptions(o)
+		return Process(buf, opts)
+	}
+
+	// Preserve the original image type, since the rotated image is encoded as PNG
+	if name := bimg.DetermineImageTypeName(buf); o.Type == "" && IsImageTypeSupportedSave(name) {
+		o.Type = name
+	}
+
+	rotated, err := RotateImage(buf, o)
+	if err != nil {
+		return Image{}, err
+	}
+
+	o.Rotate = 0
+	o.NoRotation = true
 	opts := BimgOptions(o)
-	return Process(buf, opts)
+	return Process(rotated, opts)
 }
 
 func Flip(buf []byte, o ImageOptions) (Image, error) {
@@ -140,6 +379,10 @@ func Thumbnail(buf []byte, o ImageOptions) (Image, error) {
 		return Image{}, NewError("Missing required params: width or height", BadRequest)
 	}
 
+	if o.Gravity == bimg.GravitySmart && o.Width > 0 && o.Height > 0 {
+		return SmartCrop(buf, BimgOptions(o))
+	}
+
 	return Process(buf, BimgOptions(o))
 }
 
@@ -176,6 +419,9 @@ func Convert(buf []byte, o ImageOptions) (Image, error) {
 	if ImageType(o.Type) == bimg.UNKNOWN {
 		return Image{}, NewError("Invalid image type: "+o.Type, BadRequest)
 	}
+	if !IsImageTypeSupportedSave(o.Type) {
+		return Image{}, NewError("Unsupported output image type: "+o.Type, BadRequest)
+	}
 	opts := BimgOptions(o)
 
 	return Process(buf, opts)
@@ -202,6 +448,174 @@ func Watermark(buf []byte, o ImageOptions) (Image, error) {
 	return Process(buf, opts)
 }
 
+func WatermarkImage(buf []byte, o ImageOptions) (Image, error) {
+	if len(o.WatermarkBuffer) == 0 {
+		return Image{}, NewError("Missing required param: image", BadRequest)
+	}
+	if o.Margin < 0 {
+		return Image{}, NewError("Invalid param: margin must be positive", BadRequest)
+	}
+
+	opts := BimgOptions(o)
+
+	// Preserve the original image type if no output type is defined
+	if opts.Type == bimg.UNKNOWN {
+		opts.Type = bimg.DetermineImageType(buf)
+	}
+
+	// Watermark placement depends on the final image size, so transform the image losslessly first
+	base := opts
+	base.Type = bimg.PNG
+	transformed, err := Process(buf, base)
+	if err != nil {
+		return Image{}, err
+	}
+
+	size, err := bimg.Size(transformed.Body)
+	if err != nil {
+		return Image{}, err
+	}
+
+	watermark, err := watermarkOverlay(o.WatermarkBuffer, size, o)
+	if err != nil {
+		return Image{}, NewError("Cannot process watermark image: "+err.Error(), BadRequest)
+	}
+
+	return Process(transformed.Body, bimg.Options{
+		Type:           opts.Type,
+		Quality:        opts.Quality,
+		Compression:    opts.Compression,
+		Speed:          opts.Speed,
+		NoAutoRotate:   true,
+		NoProfile:      opts.NoProfile,
+		WatermarkImage: watermark,
+	})
+}
+
+func Blur(buf []byte, o ImageOptions) (Image, error) {
+	if o.Sigma == 0 && o.MinAmpl == 0 {
+		return Image{}, NewError("Missing required param: sigma or minampl", BadRequest)
+	}
+
+	opts := BimgOptions(o)
+	return Process(buf, opts)
+}
+
+func Sharpen(buf []byte, o ImageOptions) (Image, error) {
+	if o.Radius == 0 {
+		return Image{}, NewError("Missing required param: radius", BadRequest)
+	}
+
+	opts := BimgOptions(o)
+	return Process(buf, opts)
+}
+
+// OperationsMap exposes the image operations which can be chained via pipeline.
+// Operations replying with JSON, such as Info or Palette, and Pipeline itself cannot be chained.
+var OperationsMap = map[string]Operation{
+	"resize":         Resize,
+	"enlarge":        Enlarge,
+	"extract":        Extract,
+	"crop":           Crop,
+	"rotate":         Rotate,
+	"flip":           Flip,
+	"flop":           Flop,
+	"thumbnail":      Thumbnail,
+	"zoom":           Zoom,
+	"convert":        Convert,
+	"watermark":      Watermark,
+	"watermarkimage": WatermarkImage,
+	"blur":           Blur,
+	"sharpen":        Sharpen,
+}
+
+// Pipeline performs the operations in order, using the output of each one as input of the next one.
+// The intermediate images are losslessly encoded, and the output image is encoded only once with the
+// type and encoder params defined by the steps, the last ones taking precedence.
+func Pipeline(buf []byte, o ImageOptions) (Image, error) {
+	if len(o.Operations) == 0 {
+		return Image{}, NewError("Missing or invalid required param: operations", BadRequest)
+	}
+
+	image := Image{Body: buf}
+	headers := map[string]string{}
+	output := mergeOutputOptions(ImageOptions{}, o)
+
+	for i, step := range o.Operations {
+		operation, ok := OperationsMap[step.Name]
+		if !ok {
+			return Image{}, pipelineError(i, step, "unsupported operation")
+		}
+
+		opts, paramErrors := readMapParams(step.Params)
+		if len(paramErrors) > 0 {
+			return Image{}, pipelineParamsError(i, step, paramErrors)
+		}
+
+		// Watermark image can only be loaded once per request, as top-level param
+		opts.WatermarkBuffer = o.WatermarkBuffer
+
+		// Device pixel ratio applies to every step
+		opts = ApplyDPR(opts, o.DPR)
+
+		// Relative params are resolved against the current step image
+		opts, err := resolveRelativeParams(image.Body, opts)
+		if err != nil {
+			return Image{}, pipelineError(i, step, err.Error())
+		}
+
+		err = CheckOutputLimits(opts.Width, opts.Height, o.MaxOutputDimension)
+		if err == nil {
+			err = CheckOperationLimits(step.Name, image.Body, opts, o.MaxOutputDimension)
+		}
+		if xerr, ok := err.(Error); ok {
+			perr := pipelineError(i, step, xerr.Message)
+			perr.Code = xerr.Code
+			return Image{}, perr
+		}
+
+		if opts.Type != "" && (ImageType(opts.Type) == bimg.UNKNOWN || !IsImageTypeSupportedSave(opts.Type)) {
+			return Image{}, pipelineError(i, step, ErrOutputFormat.Message)
+		}
+
+		output = mergeOutputOptions(output, opts)
+		if err := CheckEncoderParams(output, outputImageType(buf, output)); err != nil {
+			return Image{}, pipelineError(i, step, err.Error())
+		}
+
+		image, err = operation.Run(image.Body, losslessOptions(opts))
+		if err != nil {
+			return Image{}, pipelineError(i, step, err.Error())
+		}
+
+		for key, value := range image.Headers {
+			headers[key] = value
+		}
+	}
+
+	image.Headers = headers
+	return EncodeOutput(image, outputImageType(buf, output), output)
+}
+
+func pipelineError(index int, step PipelineOperation, message string) Error {
+	return NewError(fmt.Sprintf("Pipeline step #%d (%s) failed: %s", index+1, step.Name, message), BadRequest)
+}
+
+// pipelineParamsError reports the invalid params of a pipeline step, using operations[index].param as param name
+func pipelineParamsError(index int, step PipelineOperation, paramErrors []ParamError) Error {
+	messages := []string{}
+	stepErrors := []ParamError{}
+	for _, err := range paramErrors {
+		messages = append(messages, err.Param+": "+err.Message)
+		err.Param = fmt.Sprintf("operations[%d].%s", index, err.Param)
+		stepErrors = append(stepErrors, err)
+	}
+
+	perr := pipelineError(index, step, "Invalid params: "+strings.Join(messages, ", "))
+	perr.Errors = stepErrors
+	return perr
+}
+
 func Process(buf []byte, opts bimg.Options) (out Image, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -223,5 +637,72 @@ func Process(buf []byte, opts bimg.Options) (out Image, err error) {
 	}
 
 	mime := GetImageMimeType(bimg.DetermineImageType(buf))
+	if mime == "" {
+		return Image{}, ErrOutputFormat
+	}
+
 	return Image{Body: buf, Mime: mime}, nil
 }
+
+// sampleImage decodes a downscaled lossless version of the image, fitting the given size in its
+// longest side, in order to be analyzed in Go, such as for smart crop or color palette extraction.
+func sampleImage(buf []byte, size int, opts bimg.Options) (image.Image, error) {
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return nil, NewError("Cannot retrieve image metadata: "+err.Error(), BadRequest)
+	}
+
+	fitSize(meta, size, &opts)
+	opts.Type = bimg.PNG
+
+	sample, err := Process(buf, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := png.Decode(bytes.NewReader(sample.Body))
+	if err != nil {
+		return nil, NewError("Cannot decode image sample: "+err.Error(), InternalError)
+	}
+	return img, nil
+}
+
+// resolveRelativeParams resolves the relative dimensions and aspect ratio params
+// based on the image size, if required
+func resolveRelativeParams(buf []byte, o ImageOptions) (ImageOptions, error) {
+	if !hasRelativeParams(o) {
+		return o, nil
+	}
+
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return o, NewError("Cannot retrieve image metadata: "+err.Error(), BadRequest)
+	}
+
+	width, height := orientedSize(meta, bimg.Options{NoAutoRotate: o.NoRotation})
+	return ResolveRelativeParams(o, width, height)
+}
+
+// fitSize bounds the longest side of the oriented image to the given size,
+// returning the oriented image size
+func fitSize(meta bimg.ImageMetadata, size int, opts *bimg.Options) (int, int) {
+	width, height := orientedSize(meta, *opts)
+	if width > height {
+		opts.Width = int(math.Min(float64(size), float64(width)))
+	} else {
+		opts.Height = int(math.Min(float64(size), float64(height)))
+	}
+	return width, height
+}
+
+// orientedSize returns the image size after applying the auto-rotation and rotation transformations
+func orientedSize(meta bimg.ImageMetadata, opts bimg.Options) (int, int) {
+	width, height := meta.Size.Width, meta.Size.Height
+	if opts.NoAutoRotate == false && meta.Orientation >= 5 {
+		width, height = height, width
+	}
+	if opts.Rotate == bimg.D90 || opts.Rotate == bimg.D270 {
+		width, height = height, width
+	}
+	return width, height
+}
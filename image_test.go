@@ -2,7 +2,10 @@ package main
 
 import (
 	"io/ioutil"
+	"strings"
 	"testing"
+
+	"gopkg.in/h2non/bimg.v1"
 )
 
 func TestImageResize(t *testing.T) {
@@ -20,3 +23,59 @@ func TestImageResize(t *testing.T) {
 		t.Errorf("Invalid image size, expected: %dx%d", opts.Width, opts.Height)
 	}
 }
+
+func TestPipelineEncoderParams(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+	opts := ImageOptions{Operations: []PipelineOperation{
+		{Name: "convert", Params: map[string]interface{}{"type": "webp", "progressive": true}},
+	}}
+
+	_, err := Pipeline(buf, opts)
+	if err == nil || !strings.Contains(err.Error(), "progressive is not supported by webp images") {
+		t.Errorf("Expected encoder params error: %v", err)
+	}
+}
+
+func TestPipelineInvalidParams(t *testing.T) {
+	opts := ImageOptions{Operations: []PipelineOperation{
+		{Name: "blur", Params: map[string]interface{}{"sigma": "abc"}},
+		{Name: "crop", Params: map[string]interface{}{"width": float64(300)}},
+	}}
+
+	_, err := Pipeline([]byte("image"), opts)
+	xerr, ok := err.(Error)
+	if !ok || !strings.Contains(xerr.Message, "step #1 (blur) failed: Invalid params: sigma") {
+		t.Fatalf("Expected invalid params error: %v", err)
+	}
+	if len(xerr.Errors) != 1 || xerr.Errors[0].Param != "operations[0].sigma" {
+		t.Errorf("Invalid param errors: %#v", xerr.Errors)
+	}
+}
+
+func TestPipelineOutputType(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+	opts := ImageOptions{Type: "webp", Operations: []PipelineOperation{
+		{Name: "resize", Params: map[string]interface{}{"width": float64(200), "progressive": true}},
+	}}
+
+	_, err := Pipeline(buf, opts)
+	if err == nil || !strings.Contains(err.Error(), "progressive is not supported by webp images") {
+		t.Errorf("Expected top-level output type to apply: %v", err)
+	}
+}
+
+func TestPipelineEncodeOnce(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+	opts := ImageOptions{Operations: []PipelineOperation{
+		{Name: "convert", Params: map[string]interface{}{"type": "webp"}},
+		{Name: "resize", Params: map[string]interface{}{"width": 200, "quality": 50}},
+	}}
+
+	img, err := Pipeline(buf, opts)
+	if err != nil {
+		t.Fatalf("Cannot process the image: %s", err)
+	}
+	if img.Mime != "image/webp" || bimg.DetermineImageTypeName(img.Body) != "webp" {
+		t.Errorf("Invalid output image type: %s", img.Mime)
+	}
+}
@@ -31,11 +31,27 @@ var (
 	aGzip              = flag.Bool("gzip", false, "Enable gzip compression")
 	aAuthForwarding    = flag.Bool("enable-auth-forwarding", false, "Forwards X-Forward-Authorization or Authorization header to the image source server. -enable-url-source flag must be defined. Tip: secure your server from public access to prevent attack vectors")
 	aEnableURLSource   = flag.Bool("enable-url-source", false, "Enable remote HTTP URL image source processing")
+	aEnableS3Source    = flag.Bool("enable-s3-source", false, "Enable S3-compatible object storage image source processing")
+	aS3Endpoint        = flag.String("s3-endpoint", "", "S3-compatible object storage endpoint URL, such as http://localhost:9000. Defaults to S3_ENDPOINT env var or AWS S3")
+	aS3Region          = flag.String("s3-region", "", "S3 region used to sign the requests. Defaults to AWS_REGION env var or us-east-1")
+	aS3AccessKey       = flag.String("s3-access-key", "", "S3 access key ID. Defaults to AWS_ACCESS_KEY_ID env var")
+	aS3SecretKey       = flag.String("s3-secret-key", "", "S3 secret access key. Defaults to AWS_SECRET_ACCESS_KEY env var")
+	aEnableGCSSource   = flag.Bool("enable-gcs-source", false, "Enable Google Cloud Storage image source processing")
+	aGCSEndpoint       = flag.String("gcs-endpoint", "", "Google Cloud Storage endpoint URL, such as an emulator. Defaults to STORAGE_EMULATOR_HOST env var or https://storage.googleapis.com")
+	aGCSCredentials    = flag.String("gcs-credentials", "", "Google Cloud service account JSON key file path. Defaults to GOOGLE_APPLICATION_CREDENTIALS env var")
 	aEnablePlaceholder = flag.Bool("enable-placeholder", false, "Enable image response placeholder to be used in case of error")
+	aRedactGPS         = flag.Bool("redact-gps-metadata", false, "Remove GPS location fields from the full image metadata exposed by /info")
+	aSources           = flag.String("sources", "fs,http,s3,gcs,payload", "Comma separated list of the enabled image sources, by matching precedence")
 	aAlloweOrigins     = flag.String("allowed-origins", "", "Restrict remote image source processing to certain origins (separated by commas)")
 	aMaxAllowedSize    = flag.Int("max-allowed-size", 0, "Restrict maximum size of http image source (in bytes)")
+	aMaxInputPixels    = flag.Int("max-input-pixels", 0, "Restrict maximum number of pixels of the input images")
+	aMaxInputDimension = flag.Int("max-input-dimension", 0, "Restrict maximum width and height of the input images (in pixels)")
+	aMaxOutputDim      = flag.Int("max-output-dimension", 0, "Restrict maximum width and height of the output images (in pixels)")
+	aMaxDPR            = flag.Float64("max-dpr", 4, "Maximum device pixel ratio allowed via dpr param, up to 4")
+	aStrictParams      = flag.Bool("strict-params", false, "Reject requests with invalid, unknown or missing params, replying with the list of errors")
 	aKey               = flag.String("key", "", "Define API key for authorization")
 	aMount             = flag.String("mount", "", "Mount server local directory")
+	aPathOrigin        = flag.String("path-origin", "", "Origin URL resolving the image path of path-style URLs, such as /resize/w:300/path/to/image.jpg. Defaults to the mounted directory")
 	aCertFile          = flag.String("certfile", "", "TLS certificate file path")
 	aKeyFile           = flag.String("keyfile", "", "TLS private key file path")
 	aAuthorization     = flag.String("authorization", "", "Defines a constant Authorization header value passed to all the image source servers. -enable-url-source flag must be defined. This overwrites authorization headers forwarding behavior via X-Forward-Authorization")
@@ -60,6 +76,10 @@ Usage:
   imaginary -enable-url-source -allowed-origins http://localhost,http://server.com
   imaginary -enable-url-source -enable-auth-forwarding
   imaginary -enable-url-source -authorization "Basic AwDJdL2DbwrD=="
+  imaginary -enable-s3-source -s3-endpoint http://localhost:9000 -s3-region us-east-1
+  imaginary -enable-gcs-source -gcs-credentials ./service-account.json
+  imaginary -mount ~/images -enable-url-source -sources fs,http
+  imaginary -path-origin https://images.example.com
 	imaginary -enable-placeholder
 	imaginery -enable-url-source -placeholder ./placeholder.jpg
 	imaginary -h | -help
@@ -81,8 +101,14 @@ Options:
   -enable-url-source        Restrict remote image source processing to certain origins (separated by commas)
 	-enable-placeholder       Enable image response placeholder to be used in case of error [default: false]
   -enable-auth-forwarding   Forwards X-Forward-Authorization or Authorization header to the image source server. -enable-url-source flag must be defined. Tip: secure your server from public access to prevent attack vectors
+  -redact-gps-metadata      Remove GPS location fields from the full image metadata exposed by /info [default: false]
   -allowed-origins <urls>   Restrict remote image source processing to certain origins (separated by commas)
   -max-allowed-size <bytes> Restrict maximum size of http image source (in bytes)
+  -max-input-pixels <num>   Restrict maximum number of pixels of the input images
+  -max-input-dimension <px> Restrict maximum width and height of the input images (in pixels)
+  -max-output-dimension <px> Restrict maximum width and height of the output images (in pixels)
+  -max-dpr <num>            Maximum device pixel ratio allowed via dpr param, up to 4 [default: 4]
+  -strict-params            Reject requests with invalid, unknown or missing params, replying with the list of errors [default: false]
   -certfile <path>          TLS certificate file path
   -keyfile <path>           TLS private key file path
   -authorization <value>    Defines a constant Authorization header value passed to all the image source servers. -enable-url-source flag must be defined. This overwrites authorization headers forwarding behavior via X-Forward-Authorization
@@ -112,29 +138,46 @@ func main() {
 
 	port := getPort(*aPort)
 	opts := ServerOptions{
-		Port:              port,
-		Address:           *aAddr,
-		Gzip:              *aGzip,
-		CORS:              *aCors,
-		AuthForwarding:    *aAuthForwarding,
-		EnableURLSource:   *aEnableURLSource,
-		EnablePlaceholder: *aEnablePlaceholder,
-		PathPrefix:        *aPathPrefix,
-		ApiKey:            *aKey,
-		Concurrency:       *aConcurrency,
-		Burst:             *aBurst,
-		Mount:             *aMount,
-		CertFile:          *aCertFile,
-		KeyFile:           *aKeyFile,
-		Placeholder:       *aPlaceholder,
-		HttpCacheTtl:      *aHttpCacheTtl,
-		HttpReadTimeout:   *aReadTimeout,
-		HttpWriteTimeout:  *aWriteTimeout,
-		Authorization:     *aAuthorization,
-		AlloweOrigins:     parseOrigins(*aAlloweOrigins),
-		MaxAllowedSize:    *aMaxAllowedSize,
+		Port:               port,
+		Address:            *aAddr,
+		Gzip:               *aGzip,
+		CORS:               *aCors,
+		AuthForwarding:     *aAuthForwarding,
+		EnableURLSource:    *aEnableURLSource,
+		EnablePlaceholder:  *aEnablePlaceholder,
+		RedactGPSMetadata:  *aRedactGPS,
+		PathPrefix:         *aPathPrefix,
+		ApiKey:             *aKey,
+		Concurrency:        *aConcurrency,
+		Burst:              *aBurst,
+		Mount:              *aMount,
+		PathOrigin:         *aPathOrigin,
+		CertFile:           *aCertFile,
+		KeyFile:            *aKeyFile,
+		Placeholder:        *aPlaceholder,
+		HttpCacheTtl:       *aHttpCacheTtl,
+		HttpReadTimeout:    *aReadTimeout,
+		HttpWriteTimeout:   *aWriteTimeout,
+		Authorization:      *aAuthorization,
+		AlloweOrigins:      parseOrigins(*aAlloweOrigins),
+		MaxAllowedSize:     *aMaxAllowedSize,
+		MaxDPR:             *aMaxDPR,
+		MaxInputPixels:     *aMaxInputPixels,
+		MaxInputDimension:  *aMaxInputDimension,
+		MaxOutputDimension: *aMaxOutputDim,
+		StrictParams:       *aStrictParams,
+		EnableS3Source:     *aEnableS3Source,
+		S3:                 getS3Config(),
+		EnableGCSSource:    *aEnableGCSSource,
 	}
 
+	// Parse the enabled image sources
+	sources, err := ParseSources(*aSources)
+	if err != nil {
+		exitWithError("invalid -sources flag: %s", err)
+	}
+	opts.Sources = sources
+
 	// Create a memory release goroutine
 	if *aMRelease > 0 {
 		memoryRelease(*aMRelease)
@@ -145,6 +188,16 @@ func main() {
 		checkMountDirectory(*aMount)
 	}
 
+	// Read the Google Cloud Storage service account, if required
+	if *aEnableGCSSource {
+		opts.GCS = getGCSConfig()
+	}
+
+	// Check the path-style URLs origin, if present
+	if *aPathOrigin != "" {
+		checkPathOrigin(*aPathOrigin)
+	}
+
 	// Validate HTTP cache param, if present
 	if *aHttpCacheTtl != -1 {
 		checkHttpCacheTtl(*aHttpCacheTtl)
@@ -168,13 +221,18 @@ func main() {
 		opts.PlaceholderImage = placeholder
 	}
 
+	for _, name := range ImageTypes {
+		support := SupportedImageTypes[name]
+		debug("image format %s: load=%t save=%t", name, support.Load, support.Save)
+	}
+
 	debug("imaginary server listening on port :%d/%s", opts.Port, strings.TrimPrefix(opts.PathPrefix, "/"))
 
 	// Load image source providers
 	LoadSources(opts)
 
 	// Start the server
-	err := Server(opts)
+	err = Server(opts)
 	if err != nil {
 		exitWithError("cannot start the server: %s", err)
 	}
@@ -190,6 +248,26 @@ func getPort(port int) int {
 	return port
 }
 
+// getS3Config reads the S3 source config from the flags, falling back to the standard AWS env vars
+func getS3Config() S3Config {
+	getValue := func(value string, envs ...string) string {
+		for _, env := range envs {
+			if value == "" {
+				value = os.Getenv(env)
+			}
+		}
+		return value
+	}
+
+	return S3Config{
+		Endpoint:     getValue(*aS3Endpoint, "S3_ENDPOINT"),
+		Region:       getValue(*aS3Region, "AWS_REGION", "AWS_DEFAULT_REGION"),
+		AccessKey:    getValue(*aS3AccessKey, "AWS_ACCESS_KEY_ID"),
+		SecretKey:    getValue(*aS3SecretKey, "AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
 func showUsage() {
 	flag.Usage()
 	os.Exit(1)
@@ -200,6 +278,35 @@ func showVersion() {
 	os.Exit(1)
 }
 
+// getGCSConfig reads the Google Cloud Storage source config from the flags, falling back to the standard env vars
+func getGCSConfig() GCSConfig {
+	config := GCSConfig{Endpoint: *aGCSEndpoint}
+	if config.Endpoint == "" {
+		config.Endpoint = os.Getenv("STORAGE_EMULATOR_HOST")
+	}
+
+	path := *aGCSCredentials
+	if path == "" {
+		path = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if path != "" {
+		credentials, err := ReadGCSCredentials(path)
+		if err != nil {
+			exitWithError("cannot read the Google Cloud service account: %s", err)
+		}
+		config.Credentials = credentials
+	}
+
+	return config
+}
+
+func checkPathOrigin(origin string) {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		exitWithError("invalid path origin, it must be an HTTP URL: %s", origin)
+	}
+}
+
 func checkMountDirectory(path string) {
 	src, err := os.Stat(path)
 	if err != nil {
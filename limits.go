@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// readImageSize reads the image dimensions from its header, without decoding the image data.
+// Common formats are parsed natively, falling back to libvips for the rest of formats.
+func readImageSize(buf []byte) (int, int, error) {
+	if config, _, err := image.DecodeConfig(bytes.NewReader(buf)); err == nil {
+		return config.Width, config.Height, nil
+	}
+
+	// JPEG XL images are not supported by bimg
+	if DetermineImageType(buf) == JXL {
+		return vipsImageSize(buf)
+	}
+
+	size, err := bimg.Size(buf)
+	if err != nil {
+		return 0, 0, err
+	}
+	return size.Width, size.Height, nil
+}
+
+// CheckInputLimits verifies that the image dimensions, as declared in its header, don't exceed
+// the maximum allowed by the server, in order to prevent decompression bombs.
+func CheckInputLimits(buf []byte, o ServerOptions) error {
+	if o.MaxInputPixels <= 0 && o.MaxInputDimension <= 0 {
+		return nil
+	}
+
+	width, height, err := readImageSize(buf)
+	if err != nil {
+		return NewError("Cannot retrieve image size: "+err.Error(), BadRequest)
+	}
+	return checkInputSize(width, height, o)
+}
+
+// CheckPagesLimits verifies the size of the image loaded with the page, n and density params
+// before loading it, since rasterizing vector images or stacking pages may exceed the input limits
+// of the server, even if the image size declared in its header doesn't.
+func CheckPagesLimits(buf []byte, opts ImageOptions, o ServerOptions) error {
+	if o.MaxInputPixels <= 0 && o.MaxInputDimension <= 0 {
+		return nil
+	}
+
+	width, height, err := loadedPagesSize(buf, opts)
+	if err != nil {
+		return NewError("Cannot retrieve image size: "+err.Error(), BadRequest)
+	}
+	return checkInputSize(width, height, o)
+}
+
+func checkInputSize(width, height int, o ServerOptions) error {
+	if o.MaxInputDimension > 0 && (width > o.MaxInputDimension || height > o.MaxInputDimension) {
+		return NewError(fmt.Sprintf("Image dimensions %dx%d exceed the maximum allowed of %d pixels", width, height, o.MaxInputDimension), PayloadTooLarge)
+	}
+
+	if pixels := int64(width) * int64(height); o.MaxInputPixels > 0 && pixels > int64(o.MaxInputPixels) {
+		return NewError(fmt.Sprintf("Image size of %d pixels exceeds the maximum allowed of %d pixels", pixels, o.MaxInputPixels), PayloadTooLarge)
+	}
+
+	return nil
+}
+
+// CheckOutputLimits verifies that the requested output dimensions don't exceed the given maximum
+func CheckOutputLimits(width, height, max int) error {
+	if max > 0 && (width > max || height > max) {
+		return NewError(fmt.Sprintf("Output image dimensions %dx%d exceed the maximum allowed of %d pixels", width, height, max), Unprocessable)
+	}
+	return nil
+}
+
+// CheckOperationLimits verifies the output dimensions of the operations enlarging the image beyond
+// the requested width and height, such as zoom or free-angle rotation, before running them
+func CheckOperationLimits(name string, buf []byte, o ImageOptions, max int) error {
+	zoom := name == "zoom" && o.Factor > 0 && o.Width == 0 && o.Height == 0
+	rotate := name == "rotate" && !isRightAngle(o.Rotate) && !o.Inscribe
+	if max <= 0 || (!zoom && !rotate) {
+		return nil
+	}
+
+	// The zoomed area is extracted, if defined
+	if zoom && (o.Top > 0 || o.Left > 0) {
+		return CheckOutputLimits(o.AreaWidth, o.AreaHeight, max)
+	}
+
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return NewError("Cannot retrieve image metadata: "+err.Error(), BadRequest)
+	}
+
+	width, height := orientedSize(meta, bimg.Options{NoAutoRotate: o.NoRotation})
+	if zoom {
+		width, height = clampSize(float64(width)*float64(o.Factor)), clampSize(float64(height)*float64(o.Factor))
+	} else {
+		width, height = rotatedSize(width, height, o.Rotate)
+	}
+	return CheckOutputLimits(width, height, max)
+}
+
+// clampSize converts the given size to int, preventing overflows
+func clampSize(size float64) int {
+	return int(math.Min(math.Ceil(size), math.MaxInt32))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReadImageSize(t *testing.T) {
+	cases := []struct {
+		file   string
+		width  int
+		height int
+	}{
+		{"large.jpg", 1920, 1080},
+		{"test.png", 400, 300},
+	}
+
+	for _, test := range cases {
+		buf, _ := ioutil.ReadFile("fixtures/" + test.file)
+		width, height, err := readImageSize(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if width != test.width || height != test.height {
+			t.Errorf("Invalid image size for %s: %dx%d", test.file, width, height)
+		}
+	}
+}
+
+func TestCheckInputLimits(t *testing.T) {
+	buf, _ := ioutil.ReadFile("fixtures/test.png")
+
+	cases := []struct {
+		opts  ServerOptions
+		valid bool
+	}{
+		{ServerOptions{}, true},
+		{ServerOptions{MaxInputDimension: 400, MaxInputPixels: 120000}, true},
+		{ServerOptions{MaxInputDimension: 399}, false},
+		{ServerOptions{MaxInputPixels: 119999}, false},
+	}
+
+	for _, test := range cases {
+		err := CheckInputLimits(buf, test.opts)
+		if test.valid && err != nil {
+			t.Errorf("Unexpected error for %#v: %s", test.opts, err)
+		}
+		if !test.valid && (err == nil || err.(Error).Code != PayloadTooLarge) {
+			t.Errorf("Expected payload too large error for %#v", test.opts)
+		}
+	}
+}
+
+func TestCheckInputLimitsHeader(t *testing.T) {
+	// Tiny PNG declaring a 50000x50000 image in its header
+	buf, _ := ioutil.ReadFile("fixtures/test.png")
+	bomb := append([]byte{}, buf[:33]...)
+	binary.BigEndian.PutUint32(bomb[16:], 50000)
+	binary.BigEndian.PutUint32(bomb[20:], 50000)
+	binary.BigEndian.PutUint32(bomb[29:], crc32.ChecksumIEEE(bomb[12:29]))
+
+	err := CheckInputLimits(bomb, ServerOptions{MaxInputPixels: 100000000})
+	if err == nil || err.(Error).HTTPCode() != 413 {
+		t.Errorf("Expected payload too large error: %v", err)
+	}
+}
+
+func TestCheckOutputLimits(t *testing.T) {
+	if err := CheckOutputLimits(100000, 100, 0); err != nil {
+		t.Errorf("Unlimited output must not fail: %s", err)
+	}
+	if err := CheckOutputLimits(2000, 2000, 2000); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if err := CheckOutputLimits(100, 2001, 2000); err == nil || err.(Error).HTTPCode() != 422 {
+		t.Errorf("Expected unprocessable error: %v", err)
+	}
+}
+
+func TestCheckOperationLimits(t *testing.T) {
+	buf, _ := ioutil.ReadFile("fixtures/test.png")
+
+	cases := []struct {
+		name  string
+		opts  ImageOptions
+		max   int
+		valid bool
+	}{
+		{"zoom", ImageOptions{Factor: 10}, 0, true},
+		{"resize", ImageOptions{Factor: 10}, 1000, true},
+		{"rotate", ImageOptions{Rotate: 90}, 300, true},
+		{"rotate", ImageOptions{Rotate: 45, Inscribe: true}, 300, true},
+		{"zoom", ImageOptions{Factor: 10, Top: 10, AreaWidth: 100, AreaHeight: 100}, 1000, true},
+		{"zoom", ImageOptions{Factor: 10, Top: 10, AreaWidth: 2000, AreaHeight: 100}, 1000, false},
+		{"zoom", ImageOptions{Factor: 2}, 1000, true},
+		{"zoom", ImageOptions{Factor: 3}, 1000, false},
+		{"rotate", ImageOptions{Rotate: 45}, 500, true},
+		{"rotate", ImageOptions{Rotate: 45}, 400, false},
+	}
+
+	for i, test := range cases {
+		err := CheckOperationLimits(test.name, buf, test.opts, test.max)
+		if test.valid && err != nil {
+			t.Errorf("Unexpected error in case #%d (%s): %s", i, test.name, err)
+		}
+		if !test.valid && (err == nil || err.(Error).Code != Unprocessable) {
+			t.Errorf("Expected unprocessable error in case #%d (%s): %v", i, test.name, err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/base64"
+	"image"
+	"math"
+	"strings"
+)
+
+const (
+	blurHashSampleSize  = 64
+	thumbHashSampleSize = 100
+	lqipDefaultSize     = 16
+)
+
+// ImagePreview represents a low quality image placeholder and the source image size
+type ImagePreview struct {
+	Kind   string `json:"kind"`
+	Value  string `json:"value"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+const base83Characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func encodeBase83(value, length int) string {
+	buf := make([]byte, length)
+	for i := 0; i < length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i-1)))) % 83
+		buf[i] = base83Characters[digit]
+	}
+	return string(buf)
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+// readRGBAPixels reads the image pixels as non premultiplied RGBA values
+func readRGBAPixels(img image.Image) (int, int, [][4]uint8) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	pixels := make([][4]uint8, 0, width*height)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a > 0 {
+				r, g, b = r*0xffff/a, g*0xffff/a, b*0xffff/a
+			}
+			pixels = append(pixels, [4]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)})
+		}
+	}
+	return width, height, pixels
+}
+
+// BlurHash encodes the image as BlurHash string with the given number of components per axis.
+// See: https://github.com/woltapp/blurhash
+func BlurHash(img image.Image, xComponents, yComponents int) string {
+	width, height, pixels := readRGBAPixels(img)
+	factors := make([][3]float64, 0, xComponents*yComponents)
+
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var r, g, b float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					pixel := pixels[y*width+x]
+					r += basis * sRGBToLinear(pixel[0])
+					g += basis * sRGBToLinear(pixel[1])
+					b += basis * sRGBToLinear(pixel[2])
+				}
+			}
+
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{r * scale, g * scale, b * scale})
+		}
+	}
+
+	hash := encodeBase83((xComponents-1)+(yComponents-1)*9, 1)
+
+	maximumValue := 1.0
+	if len(factors) > 1 {
+		actualMaximumValue := 0.0
+		for _, factor := range factors[1:] {
+			for _, value := range factor {
+				actualMaximumValue = math.Max(actualMaximumValue, math.Abs(value))
+			}
+		}
+		quantisedMaximumValue := int(math.Max(0, math.Min(82, math.Floor(actualMaximumValue*166-0.5))))
+		maximumValue = float64(quantisedMaximumValue+1) / 166
+		hash += encodeBase83(quantisedMaximumValue, 1)
+	} else {
+		hash += encodeBase83(0, 1)
+	}
+
+	dc := factors[0]
+	hash += encodeBase83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+
+	for _, factor := range factors[1:] {
+		quantise := func(value float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(value/maximumValue, 0.5)*9+9.5))))
+		}
+		hash += encodeBase83(quantise(factor[0])*19*19+quantise(factor[1])*19+quantise(factor[2]), 2)
+	}
+
+	return hash
+}
+
+// ThumbHash encodes the image as base64 encoded ThumbHash. The image must fit in 100x100 pixels.
+// See: https://github.com/evanw/thumbhash
+func ThumbHash(img image.Image) string {
+	width, height, pixels := readRGBAPixels(img)
+	round := func(value float64) int { return int(math.Floor(value + 0.5)) }
+
+	// Average color, weighted by alpha
+	var avgR, avgG, avgB, avgA float64
+	for _, pixel := range pixels {
+		alpha := float64(pixel[3]) / 255
+		avgR += alpha / 255 * float64(pixel[0])
+		avgG += alpha / 255 * float64(pixel[1])
+		avgB += alpha / 255 * float64(pixel[2])
+		avgA += alpha
+	}
+	if avgA > 0 {
+		avgR, avgG, avgB = avgR/avgA, avgG/avgA, avgB/avgA
+	}
+
+	hasAlpha := avgA < float64(width*height)
+	limit := 7.0
+	if hasAlpha {
+		limit = 5
+	}
+	longest := math.Max(float64(width), float64(height))
+	lx := int(math.Max(1, float64(round(limit*float64(width)/longest))))
+	ly := int(math.Max(1, float64(round(limit*float64(height)/longest))))
+
+	// Convert to luminance, yellow-blue, red-green and alpha channels, composited atop the average color
+	size := width * height
+	l, p, q, a := make([]float64, size), make([]float64, size), make([]float64, size), make([]float64, size)
+	for i, pixel := range pixels {
+		alpha := float64(pixel[3]) / 255
+		r := avgR*(1-alpha) + alpha/255*float64(pixel[0])
+		g := avgG*(1-alpha) + alpha/255*float64(pixel[1])
+		b := avgB*(1-alpha) + alpha/255*float64(pixel[2])
+		l[i] = (r + g + b) / 3
+		p[i] = (r+g)/2 - b
+		q[i] = r - g
+		a[i] = alpha
+	}
+
+	encodeChannel := func(channel []float64, nx, ny int) (float64, []float64, float64) {
+		var dc, scale float64
+		ac := []float64{}
+		fx := make([]float64, width)
+
+		for cy := 0; cy < ny; cy++ {
+			for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+				for x := 0; x < width; x++ {
+					fx[x] = math.Cos(math.Pi / float64(width) * float64(cx) * (float64(x) + 0.5))
+				}
+
+				f := 0.0
+				for y := 0; y < height; y++ {
+					fy := math.Cos(math.Pi / float64(height) * float64(cy) * (float64(y) + 0.5))
+					for x := 0; x < width; x++ {
+						f += channel[x+y*width] * fx[x] * fy
+					}
+				}
+				f /= float64(size)
+
+				if cx > 0 || cy > 0 {
+					ac = append(ac, f)
+					scale = math.Max(scale, math.Abs(f))
+				} else {
+					dc = f
+				}
+			}
+		}
+
+		if scale > 0 {
+			for i := range ac {
+				ac[i] = 0.5 + 0.5/scale*ac[i]
+			}
+		}
+		return dc, ac, scale
+	}
+
+	lDC, lAC, lScale := encodeChannel(l, int(math.Max(3, float64(lx))), int(math.Max(3, float64(ly))))
+	pDC, pAC, pScale := encodeChannel(p, 3, 3)
+	qDC, qAC, qScale := encodeChannel(q, 3, 3)
+
+	isLandscape := width > height
+	header24 := round(63*lDC) | round(31.5+31.5*pDC)<<6 | round(31.5+31.5*qDC)<<12 | round(31*lScale)<<18
+	header16 := ly
+	if !isLandscape {
+		header16 = lx
+	}
+	header16 |= round(63*pScale)<<3 | round(63*qScale)<<9
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	if isLandscape {
+		header16 |= 1 << 15
+	}
+
+	hash := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+	channels := [][]float64{lAC, pAC, qAC}
+	if hasAlpha {
+		aDC, aAC, aScale := encodeChannel(a, 5, 5)
+		hash = append(hash, byte(round(15*aDC)|round(15*aScale)<<4))
+		channels = append(channels, aAC)
+	}
+
+	// Varying factors, packed as 4 bits values
+	start, index := len(hash), 0
+	for _, ac := range channels {
+		for _, f := range ac {
+			position := start + index>>1
+			if position >= len(hash) {
+				hash = append(hash, 0)
+			}
+			hash[position] |= byte(round(15*f) << uint((index&1)<<2))
+			index++
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(hash)
+}
+
+// DataURI encodes the image buffer as base64 data URI
+func DataURI(buf []byte, mime string) string {
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(buf)
+}
+
+func parseLQIPKind(kind string) string {
+	kind = strings.TrimSpace(strings.ToLower(kind))
+	if kind == "" {
+		return "blurhash"
+	}
+	return kind
+}
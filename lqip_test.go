@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/base64"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func solidImage(width, height int, c color.NRGBA) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestEncodeBase83(t *testing.T) {
+	cases := []struct {
+		value  int
+		length int
+		result string
+	}{
+		{0, 1, "0"},
+		{82, 1, "~"},
+		{83, 2, "10"},
+		{3429, 2, "fQ"},
+	}
+
+	for _, test := range cases {
+		if res := encodeBase83(test.value, test.length); res != test.result {
+			t.Errorf("Invalid base83 value for %d: %s != %s", test.value, res, test.result)
+		}
+	}
+}
+
+func TestBlurHash(t *testing.T) {
+	img := solidImage(8, 6, color.NRGBA{255, 0, 0, 255})
+
+	hash := BlurHash(img, 1, 1)
+	if expected := "00" + encodeBase83(0xff0000, 4); hash != expected {
+		t.Errorf("Invalid blurhash: %s != %s", hash, expected)
+	}
+
+	hash = BlurHash(img, 4, 3)
+	if len(hash) != 28 {
+		t.Fatalf("Invalid blurhash length: %d", len(hash))
+	}
+	if hash[0] != 'L' || hash[2:6] != encodeBase83(0xff0000, 4) {
+		t.Errorf("Invalid blurhash: %s", hash)
+	}
+}
+
+func TestThumbHash(t *testing.T) {
+	hash, err := base64.StdEncoding.DecodeString(ThumbHash(solidImage(40, 20, color.NRGBA{0, 0, 255, 255})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hash) < 5 {
+		t.Fatalf("Invalid thumbhash length: %d", len(hash))
+	}
+	if hash[2]&0x80 != 0 {
+		t.Error("Opaque image must not have the alpha flag")
+	}
+	if hash[4]&0x80 == 0 {
+		t.Error("Landscape image must have the landscape flag")
+	}
+
+	hash, _ = base64.StdEncoding.DecodeString(ThumbHash(solidImage(20, 40, color.NRGBA{0, 0, 255, 100})))
+	if hash[2]&0x80 == 0 {
+		t.Error("Transparent image must have the alpha flag")
+	}
+	if hash[4]&0x80 != 0 {
+		t.Error("Portrait image must not have the landscape flag")
+	}
+}
+
+func TestDataURI(t *testing.T) {
+	if uri := DataURI([]byte("foo"), "image/png"); uri != "data:image/png;base64,Zm9v" {
+		t.Errorf("Invalid data URI: %s", uri)
+	}
+}
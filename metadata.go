@@ -0,0 +1,733 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+// ImageMetadataInfo represents the structured EXIF, IPTC and XMP image metadata
+type ImageMetadataInfo struct {
+	EXIF map[string]interface{} `json:"exif,omitempty"`
+	IPTC map[string]interface{} `json:"iptc,omitempty"`
+	XMP  map[string]interface{} `json:"xmp,omitempty"`
+}
+
+// rawMetadata stores the raw metadata payloads found in an image container
+type rawMetadata struct {
+	exif []byte
+	iptc []byte
+	xmp  []byte
+}
+
+var (
+	jpegExifHeader      = []byte("Exif\x00\x00")
+	jpegXMPHeader       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegPhotoshopHeader = []byte("Photoshop 3.0\x00")
+	pngSignature        = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// ReadMetadata reads the EXIF, IPTC and XMP metadata from JPEG, PNG, WEBP and TIFF images.
+// GPS related fields are removed if redactGPS is true.
+func ReadMetadata(buf []byte, redactGPS bool) ImageMetadataInfo {
+	raw := readRawMetadata(buf)
+	info := ImageMetadataInfo{}
+
+	// TIFF images expose their metadata in the image file directory itself
+	if isTIFF(buf) {
+		raw.exif = buf
+	}
+
+	if len(raw.exif) > 0 {
+		exif := &exifReader{data: raw.exif, tags: map[string]interface{}{}}
+		exif.read()
+		info.EXIF = exif.tags
+
+		if len(raw.iptc) == 0 {
+			raw.iptc = exif.iptc
+		}
+		if len(raw.xmp) == 0 {
+			raw.xmp = exif.xmp
+		}
+	}
+	if len(raw.iptc) > 0 {
+		info.IPTC = parseIPTC(raw.iptc)
+	}
+	if len(raw.xmp) > 0 {
+		info.XMP = parseXMP(raw.xmp, redactGPS)
+	}
+
+	if redactGPS {
+		redactGPSMetadata(info.EXIF, "GPS")
+	}
+
+	return info
+}
+
+func redactGPSMetadata(tags map[string]interface{}, prefix string) {
+	for key := range tags {
+		if strings.HasPrefix(key, prefix) {
+			delete(tags, key)
+		}
+	}
+}
+
+func readRawMetadata(buf []byte) rawMetadata {
+	if len(buf) > 2 && buf[0] == 0xFF && buf[1] == 0xD8 {
+		return readJPEGMetadata(buf)
+	}
+	if bytes.HasPrefix(buf, pngSignature) {
+		return readPNGMetadata(buf)
+	}
+	if len(buf) > 12 && string(buf[0:4]) == "RIFF" && string(buf[8:12]) == "WEBP" {
+		return readWEBPMetadata(buf)
+	}
+	return rawMetadata{}
+}
+
+func isTIFF(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte("II*\x00")) || bytes.HasPrefix(buf, []byte("MM\x00*"))
+}
+
+func readJPEGMetadata(buf []byte) rawMetadata {
+	raw := rawMetadata{}
+
+	for i := 2; i+4 <= len(buf); {
+		if buf[i] != 0xFF {
+			break
+		}
+
+		marker := buf[i+1]
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		// Markers without payload
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD8) {
+			i += 2
+			continue
+		}
+		// Start of scan or end of image: no more metadata segments
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(buf[i+2 : i+4]))
+		if length < 2 || i+2+length > len(buf) {
+			break
+		}
+		payload := buf[i+4 : i+2+length]
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, jpegExifHeader):
+			raw.exif = payload[len(jpegExifHeader):]
+		case marker == 0xE1 && bytes.HasPrefix(payload, jpegXMPHeader):
+			raw.xmp = payload[len(jpegXMPHeader):]
+		case marker == 0xED && bytes.HasPrefix(payload, jpegPhotoshopHeader):
+			raw.iptc = readPhotoshopIPTC(payload[len(jpegPhotoshopHeader):])
+		}
+
+		i += 2 + length
+	}
+
+	return raw
+}
+
+func readPNGMetadata(buf []byte) rawMetadata {
+	raw := rawMetadata{}
+
+	for i := len(pngSignature); i+8 <= len(buf); {
+		length := int(binary.BigEndian.Uint32(buf[i : i+4]))
+		kind := string(buf[i+4 : i+8])
+		if length < 0 || i+12+length > len(buf) {
+			break
+		}
+		data := buf[i+8 : i+8+length]
+
+		switch kind {
+		case "eXIf":
+			raw.exif = data
+		case "iTXt":
+			if xmp := readPNGInternationalText(data, "XML:com.adobe.xmp"); xmp != nil {
+				raw.xmp = xmp
+			}
+		case "IEND":
+			return raw
+		}
+
+		i += 12 + length
+	}
+
+	return raw
+}
+
+// readPNGInternationalText returns the iTXt chunk text if it matches the given keyword
+func readPNGInternationalText(data []byte, keyword string) []byte {
+	fields := bytes.SplitN(data, []byte{0}, 2)
+	if len(fields) != 2 || string(fields[0]) != keyword || len(fields[1]) < 2 {
+		return nil
+	}
+
+	compressed := fields[1][0] == 1
+	// Skip compression flag, compression method, language tag and translated keyword
+	fields = bytes.SplitN(fields[1][2:], []byte{0}, 3)
+	if len(fields) != 3 {
+		return nil
+	}
+
+	text := fields[2]
+	if compressed {
+		reader, err := zlib.NewReader(bytes.NewReader(text))
+		if err != nil {
+			return nil
+		}
+		defer reader.Close()
+		if text, err = ioutil.ReadAll(reader); err != nil {
+			return nil
+		}
+	}
+	return text
+}
+
+func readWEBPMetadata(buf []byte) rawMetadata {
+	raw := rawMetadata{}
+
+	for i := 12; i+8 <= len(buf); {
+		kind := string(buf[i : i+4])
+		length := int(binary.LittleEndian.Uint32(buf[i+4 : i+8]))
+		if length < 0 || i+8+length > len(buf) {
+			break
+		}
+		data := buf[i+8 : i+8+length]
+
+		switch kind {
+		case "EXIF":
+			raw.exif = bytes.TrimPrefix(data, jpegExifHeader)
+		case "XMP ":
+			raw.xmp = data
+		}
+
+		i += 8 + length + length%2
+	}
+
+	return raw
+}
+
+// readPhotoshopIPTC reads the IPTC-IIM data from Photoshop image resource blocks
+func readPhotoshopIPTC(data []byte) []byte {
+	for i := 0; i+7 <= len(data); {
+		if string(data[i:i+4]) != "8BIM" {
+			break
+		}
+
+		id := binary.BigEndian.Uint16(data[i+4 : i+6])
+		// Pascal string resource name, padded to even size
+		nameLength := int(data[i+6]) + 1
+		nameLength += nameLength % 2
+
+		offset := i + 6 + nameLength
+		if offset+4 > len(data) {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		offset += 4
+		if size < 0 || offset+size > len(data) {
+			break
+		}
+
+		if id == 0x0404 {
+			return data[offset : offset+size]
+		}
+
+		i = offset + size + size%2
+	}
+	return nil
+}
+
+var iptcDatasets = map[byte]string{
+	5:   "ObjectName",
+	7:   "EditStatus",
+	10:  "Urgency",
+	15:  "Category",
+	20:  "SupplementalCategories",
+	25:  "Keywords",
+	40:  "SpecialInstructions",
+	55:  "DateCreated",
+	60:  "TimeCreated",
+	80:  "Byline",
+	85:  "BylineTitle",
+	90:  "City",
+	92:  "Sublocation",
+	95:  "ProvinceState",
+	100: "CountryCode",
+	101: "Country",
+	103: "OriginalTransmissionReference",
+	105: "Headline",
+	110: "Credit",
+	115: "Source",
+	116: "CopyrightNotice",
+	118: "Contact",
+	120: "Caption",
+	122: "Writer",
+}
+
+var iptcRepeatableDatasets = map[byte]bool{20: true, 25: true, 80: true, 85: true, 118: true, 122: true}
+
+// parseIPTC parses the IPTC-IIM application record (record number 2) datasets
+func parseIPTC(data []byte) map[string]interface{} {
+	tags := map[string]interface{}{}
+
+	for i := 0; i+5 <= len(data); {
+		if data[i] != 0x1C {
+			break
+		}
+
+		record, dataset := data[i+1], data[i+2]
+		size := int(binary.BigEndian.Uint16(data[i+3 : i+5]))
+		// Extended datasets are not used for textual values
+		if size&0x8000 != 0 || i+5+size > len(data) {
+			break
+		}
+		value := strings.TrimSpace(string(data[i+5 : i+5+size]))
+		i += 5 + size
+
+		name, ok := iptcDatasets[dataset]
+		if record != 2 || !ok || value == "" {
+			continue
+		}
+
+		if iptcRepeatableDatasets[dataset] {
+			values, _ := tags[name].([]string)
+			tags[name] = append(values, value)
+			continue
+		}
+		tags[name] = value
+	}
+
+	return tags
+}
+
+var (
+	exifIFD0Tags = map[uint16]string{
+		0x010E: "ImageDescription",
+		0x010F: "Make",
+		0x0110: "Model",
+		0x0112: "Orientation",
+		0x011A: "XResolution",
+		0x011B: "YResolution",
+		0x0128: "ResolutionUnit",
+		0x0131: "Software",
+		0x0132: "DateTime",
+		0x013B: "Artist",
+		0x8298: "Copyright",
+	}
+	exifSubIFDTags = map[uint16]string{
+		0x829A: "ExposureTime",
+		0x829D: "FNumber",
+		0x8822: "ExposureProgram",
+		0x8827: "ISOSpeedRatings",
+		0x9000: "ExifVersion",
+		0x9003: "DateTimeOriginal",
+		0x9004: "DateTimeDigitized",
+		0x9010: "OffsetTime",
+		0x9011: "OffsetTimeOriginal",
+		0x9201: "ShutterSpeedValue",
+		0x9202: "ApertureValue",
+		0x9204: "ExposureBiasValue",
+		0x9207: "MeteringMode",
+		0x9209: "Flash",
+		0x920A: "FocalLength",
+		0x9286: "UserComment",
+		0xA002: "PixelXDimension",
+		0xA003: "PixelYDimension",
+		0xA405: "FocalLengthIn35mmFilm",
+		0xA430: "CameraOwnerName",
+		0xA431: "BodySerialNumber",
+		0xA433: "LensMake",
+		0xA434: "LensModel",
+	}
+	exifGPSTags = map[uint16]string{
+		0x0001: "GPSLatitudeRef",
+		0x0002: "GPSLatitude",
+		0x0003: "GPSLongitudeRef",
+		0x0004: "GPSLongitude",
+		0x0005: "GPSAltitudeRef",
+		0x0006: "GPSAltitude",
+		0x0007: "GPSTimeStamp",
+		0x0010: "GPSImgDirectionRef",
+		0x0011: "GPSImgDirection",
+		0x001D: "GPSDateStamp",
+	}
+)
+
+const (
+	exifSubIFDPointer = 0x8769
+	exifGPSIFDPointer = 0x8825
+	exifXMPTag        = 0x02BC
+	exifIPTCTag       = 0x83BB
+)
+
+// Byte size of each TIFF field type
+var exifTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// exifReader reads the known tags from the TIFF structure used by EXIF
+type exifReader struct {
+	data    []byte
+	order   binary.ByteOrder
+	tags    map[string]interface{}
+	iptc    []byte
+	xmp     []byte
+	visited map[uint32]bool
+}
+
+func (r *exifReader) read() {
+	if len(r.data) < 8 {
+		return
+	}
+
+	switch string(r.data[0:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return
+	}
+
+	r.visited = map[uint32]bool{}
+	r.readIFD(r.order.Uint32(r.data[4:8]), exifIFD0Tags, true)
+	r.normalizeGPS()
+}
+
+// readIFD reads the known tags of the image file directory at the given offset.
+// Sub directories pointers, such as EXIF or GPS ones, are only followed from the root directory.
+func (r *exifReader) readIFD(offset uint32, names map[uint16]string, root bool) {
+	if r.visited[offset] || int(offset)+2 > len(r.data) {
+		return
+	}
+	r.visited[offset] = true
+
+	count := int(r.order.Uint16(r.data[offset : offset+2]))
+	for i := 0; i < count; i++ {
+		entry := int(offset) + 2 + i*12
+		if entry+12 > len(r.data) {
+			return
+		}
+
+		tag := r.order.Uint16(r.data[entry : entry+2])
+		kind := r.order.Uint16(r.data[entry+2 : entry+4])
+
+		switch {
+		case root && tag == exifSubIFDPointer:
+			r.readIFD(r.order.Uint32(r.data[entry+8:entry+12]), exifSubIFDTags, false)
+		case root && tag == exifGPSIFDPointer:
+			r.readIFD(r.order.Uint32(r.data[entry+8:entry+12]), exifGPSTags, false)
+		case root && tag == exifXMPTag:
+			r.xmp = r.rawValue(entry, kind)
+		case root && tag == exifIPTCTag:
+			r.iptc = r.rawValue(entry, kind)
+		default:
+			name, ok := names[tag]
+			if !ok {
+				continue
+			}
+			if value := r.readValue(entry, kind); value != nil {
+				r.tags[name] = r.formatValue(tag, value)
+			}
+		}
+	}
+}
+
+// rawValue returns the raw bytes of the given IFD entry value
+func (r *exifReader) rawValue(entry int, kind uint16) []byte {
+	size, ok := exifTypeSizes[kind]
+	if !ok {
+		return nil
+	}
+
+	count := int(r.order.Uint32(r.data[entry+4 : entry+8]))
+	length := size * count
+	if count <= 0 || length <= 0 || length > len(r.data) {
+		return nil
+	}
+
+	start := entry + 8
+	if length > 4 {
+		start = int(r.order.Uint32(r.data[entry+8 : entry+12]))
+	}
+	if start < 0 || start+length > len(r.data) {
+		return nil
+	}
+	return r.data[start : start+length]
+}
+
+// readValue reads the field value of the given IFD entry. Numeric fields are returned as
+// []float64, text as string and undefined fields as []byte.
+func (r *exifReader) readValue(entry int, kind uint16) interface{} {
+	data := r.rawValue(entry, kind)
+	if data == nil {
+		return nil
+	}
+
+	switch kind {
+	case 2:
+		return strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+	case 7:
+		return data
+	}
+
+	size := exifTypeSizes[kind]
+	count := len(data) / size
+	values := make([]float64, count)
+	for i := range values {
+		field := data[i*size : (i+1)*size]
+		switch kind {
+		case 1:
+			values[i] = float64(field[0])
+		case 6:
+			values[i] = float64(int8(field[0]))
+		case 3:
+			values[i] = float64(r.order.Uint16(field))
+		case 8:
+			values[i] = float64(int16(r.order.Uint16(field)))
+		case 4:
+			values[i] = float64(r.order.Uint32(field))
+		case 9:
+			values[i] = float64(int32(r.order.Uint32(field)))
+		case 5, 10:
+			num, den := float64(r.order.Uint32(field[0:4])), float64(r.order.Uint32(field[4:8]))
+			if kind == 10 {
+				num, den = float64(int32(r.order.Uint32(field[0:4]))), float64(int32(r.order.Uint32(field[4:8])))
+			}
+			if den != 0 {
+				values[i] = num / den
+			}
+		case 11:
+			values[i] = float64(math.Float32frombits(r.order.Uint32(field)))
+		case 12:
+			values[i] = math.Float64frombits(r.order.Uint64(field))
+		}
+	}
+	return values
+}
+
+func (r *exifReader) formatValue(tag uint16, value interface{}) interface{} {
+	switch value := value.(type) {
+	case []float64:
+		if len(value) == 1 {
+			return value[0]
+		}
+		return value
+	case []byte:
+		// UserComment is prefixed by its 8 bytes character code
+		if tag == 0x9286 && len(value) > 8 {
+			value = value[8:]
+		}
+		return strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+	}
+	return value
+}
+
+// normalizeGPS converts the GPS coordinates from degrees, minutes and seconds to signed decimal degrees
+func (r *exifReader) normalizeGPS() {
+	for _, name := range []string{"GPSLatitude", "GPSLongitude"} {
+		dms, ok := r.tags[name].([]float64)
+		if !ok || len(dms) != 3 {
+			continue
+		}
+
+		degrees := dms[0] + dms[1]/60 + dms[2]/3600
+		if ref, _ := r.tags[name+"Ref"].(string); ref == "S" || ref == "W" {
+			degrees = -degrees
+		}
+		r.tags[name] = toFixed(degrees, 6)
+	}
+
+	// Altitude reference 1 means below sea level
+	if ref, ok := r.tags["GPSAltitudeRef"].(float64); ok {
+		if altitude, ok := r.tags["GPSAltitude"].(float64); ok && ref == 1 {
+			r.tags["GPSAltitude"] = -altitude
+		}
+		delete(r.tags, "GPSAltitudeRef")
+	}
+}
+
+const (
+	rdfNamespace     = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmpExifNamespace = "http://ns.adobe.com/exif/1.0/"
+)
+
+var xmpNamespaces = map[string]string{
+	"http://purl.org/dc/elements/1.1/":            "dc",
+	"http://ns.adobe.com/xap/1.0/":                "xmp",
+	"http://ns.adobe.com/xap/1.0/rights/":         "xmpRights",
+	"http://ns.adobe.com/xap/1.0/mm/":             "xmpMM",
+	"http://ns.adobe.com/photoshop/1.0/":          "photoshop",
+	xmpExifNamespace:                              "exif",
+	"http://ns.adobe.com/exif/1.0/aux/":           "aux",
+	"http://ns.adobe.com/tiff/1.0/":               "tiff",
+	"http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/": "Iptc4xmpCore",
+	"http://iptc.org/std/Iptc4xmpExt/2008-02-29/": "Iptc4xmpExt",
+}
+
+// xmpNode represents a generic XML element of a XMP packet
+type xmpNode struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	text     string
+	children []*xmpNode
+}
+
+// parseXMP parses the RDF descriptions of the XMP packet as a flat map of properties,
+// using the namespace prefixes as key prefix, such as dc:creator.
+// The EXIF GPS properties are removed if redactGPS is true.
+func parseXMP(data []byte, redactGPS bool) map[string]interface{} {
+	tags := map[string]interface{}{}
+	prefixes := map[string]string{}
+	for uri, prefix := range xmpNamespaces {
+		prefixes[uri] = prefix
+	}
+
+	root := &xmpNode{}
+	stack := []*xmpNode{root}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+
+		parent := stack[len(stack)-1]
+		switch token := token.(type) {
+		case xml.StartElement:
+			for _, attr := range token.Attr {
+				if attr.Name.Space == "xmlns" {
+					prefixes[attr.Value] = attr.Name.Local
+				}
+			}
+			node := &xmpNode{name: token.Name, attrs: token.Attr}
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.text += string(token)
+		}
+	}
+
+	if redactGPS {
+		redactXMPGPS(root)
+	}
+
+	var walk func(node *xmpNode)
+	walk = func(node *xmpNode) {
+		if node.name.Space == rdfNamespace && node.name.Local == "Description" {
+			for key, value := range xmpProperties(node, prefixes) {
+				tags[key] = value
+			}
+			return
+		}
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	return tags
+}
+
+// redactXMPGPS removes the EXIF GPS properties, either attributes or elements, from the XMP tree.
+// Properties are matched by namespace URI, since each document chooses its own namespace prefixes.
+func redactXMPGPS(node *xmpNode) {
+	isGPS := func(name xml.Name) bool {
+		return name.Space == xmpExifNamespace && strings.HasPrefix(name.Local, "GPS")
+	}
+
+	attrs := node.attrs[:0]
+	for _, attr := range node.attrs {
+		if !isGPS(attr.Name) {
+			attrs = append(attrs, attr)
+		}
+	}
+	node.attrs = attrs
+
+	children := node.children[:0]
+	for _, child := range node.children {
+		if !isGPS(child.name) {
+			redactXMPGPS(child)
+			children = append(children, child)
+		}
+	}
+	node.children = children
+}
+
+func xmpProperties(node *xmpNode, prefixes map[string]string) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for _, attr := range node.attrs {
+		if attr.Name.Space == "xmlns" || attr.Name.Space == rdfNamespace || attr.Name.Space == "" {
+			continue
+		}
+		properties[xmpKey(attr.Name, prefixes)] = attr.Value
+	}
+	for _, child := range node.children {
+		properties[xmpKey(child.name, prefixes)] = xmpValue(child, prefixes)
+	}
+	return properties
+}
+
+func xmpValue(node *xmpNode, prefixes map[string]string) interface{} {
+	for _, attr := range node.attrs {
+		if attr.Name.Space == rdfNamespace && attr.Name.Local == "resource" {
+			return attr.Value
+		}
+	}
+
+	if len(node.children) == 0 {
+		return strings.TrimSpace(node.text)
+	}
+
+	container := node.children[0]
+	if container.name.Space == rdfNamespace {
+		switch container.name.Local {
+		case "Bag", "Seq":
+			values := []interface{}{}
+			for _, item := range container.children {
+				values = append(values, xmpValue(item, prefixes))
+			}
+			return values
+		case "Alt":
+			// Language alternatives: prefer the default language
+			for _, item := range container.children {
+				for _, attr := range item.attrs {
+					if attr.Name.Local == "lang" && attr.Value == "x-default" {
+						return xmpValue(item, prefixes)
+					}
+				}
+			}
+			if len(container.children) > 0 {
+				return xmpValue(container.children[0], prefixes)
+			}
+			return ""
+		case "Description":
+			return xmpProperties(container, prefixes)
+		}
+	}
+
+	// Structured value, such as rdf:parseType="Resource"
+	return xmpProperties(node, prefixes)
+}
+
+func xmpKey(name xml.Name, prefixes map[string]string) string {
+	if prefix, ok := prefixes[name.Space]; ok {
+		return prefix + ":" + name.Local
+	}
+	return name.Local
+}
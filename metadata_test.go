@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// exifFixture builds a little endian TIFF structure with an EXIF and GPS sub directories
+func exifFixture() []byte {
+	buf := &bytes.Buffer{}
+	le := binary.LittleEndian
+	write := func(v interface{}) { binary.Write(buf, le, v) }
+	entry := func(tag, kind uint16, count, value uint32) {
+		write(tag)
+		write(kind)
+		write(count)
+		write(value)
+	}
+
+	// Header + IFD0 at offset 8 with 4 entries
+	buf.WriteString("II*\x00")
+	write(uint32(8))
+	write(uint16(4))
+	entry(0x010F, 2, 6, 62)      // Make -> "Canon"
+	entry(0x8298, 2, 10, 68)     // Copyright -> "(c) ACME"
+	entry(0x8769, 4, 1, 78)      // EXIF IFD pointer
+	entry(0x8825, 4, 1, 116)     // GPS IFD pointer
+	write(uint32(0))             // next IFD
+	buf.WriteString("Canon\x00") // offset 62
+	buf.WriteString("(c) ACME\x00\x00")
+
+	// EXIF IFD at offset 78 with 1 entry
+	write(uint16(1))
+	entry(0x9003, 2, 20, 96)
+	write(uint32(0))
+	buf.WriteString("2017:01:02 03:04:05\x00") // offset 96
+
+	// GPS IFD at offset 116 with 4 entries
+	write(uint16(4))
+	entry(0x0001, 2, 2, uint32('N'))
+	entry(0x0002, 5, 3, 170)
+	entry(0x0003, 2, 2, uint32('W'))
+	entry(0x0004, 5, 3, 194)
+	write(uint32(0))
+	for _, v := range []uint32{40, 1, 30, 1, 0, 1, 3, 1, 45, 1, 0, 1} {
+		write(v)
+	}
+
+	return buf.Bytes()
+}
+
+func jpegFixture(segments ...[]byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{0xFF, 0xD8})
+	for _, segment := range segments {
+		buf.Write(segment)
+	}
+	buf.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9})
+	return buf.Bytes()
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func iptcFixture() []byte {
+	iim := &bytes.Buffer{}
+	dataset := func(number byte, value string) {
+		iim.Write([]byte{0x1C, 2, number})
+		binary.Write(iim, binary.BigEndian, uint16(len(value)))
+		iim.WriteString(value)
+	}
+	dataset(120, "A caption")
+	dataset(25, "sky")
+	dataset(25, "sea")
+	dataset(116, "ACME")
+
+	resource := &bytes.Buffer{}
+	resource.WriteString("Photoshop 3.0\x00")
+	resource.WriteString("8BIM")
+	binary.Write(resource, binary.BigEndian, uint16(0x0404))
+	resource.Write([]byte{0, 0})
+	binary.Write(resource, binary.BigEndian, uint32(iim.Len()))
+	resource.Write(iim.Bytes())
+	return resource.Bytes()
+}
+
+const xmpFixture = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmp:CreateDate="2017-01-02T03:04:05" exif:GPSLatitude="40,30.0N">
+   <dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li></rdf:Seq></dc:creator>
+   <dc:subject><rdf:Bag><rdf:li>sky</rdf:li><rdf:li>sea</rdf:li></rdf:Bag></dc:subject>
+   <dc:rights><rdf:Alt><rdf:li xml:lang="x-default">ACME</rdf:li></rdf:Alt></dc:rights>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func TestReadMetadata(t *testing.T) {
+	buf := jpegFixture(
+		jpegSegment(0xE1, append([]byte("Exif\x00\x00"), exifFixture()...)),
+		jpegSegment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmpFixture...)),
+		jpegSegment(0xED, iptcFixture()),
+	)
+
+	info := ReadMetadata(buf, false)
+
+	if info.EXIF["Make"] != "Canon" || info.EXIF["Copyright"] != "(c) ACME" {
+		t.Errorf("Invalid EXIF tags: %#v", info.EXIF)
+	}
+	if info.EXIF["DateTimeOriginal"] != "2017:01:02 03:04:05" {
+		t.Errorf("Invalid EXIF capture date: %#v", info.EXIF["DateTimeOriginal"])
+	}
+	if info.EXIF["GPSLatitude"] != 40.5 || info.EXIF["GPSLongitude"] != -3.75 {
+		t.Errorf("Invalid GPS coordinates: %#v, %#v", info.EXIF["GPSLatitude"], info.EXIF["GPSLongitude"])
+	}
+
+	if info.IPTC["Caption"] != "A caption" || info.IPTC["CopyrightNotice"] != "ACME" {
+		t.Errorf("Invalid IPTC tags: %#v", info.IPTC)
+	}
+	if keywords, _ := info.IPTC["Keywords"].([]string); len(keywords) != 2 || keywords[1] != "sea" {
+		t.Errorf("Invalid IPTC keywords: %#v", info.IPTC["Keywords"])
+	}
+
+	if info.XMP["xmp:CreateDate"] != "2017-01-02T03:04:05" || info.XMP["dc:rights"] != "ACME" {
+		t.Errorf("Invalid XMP properties: %#v", info.XMP)
+	}
+	if subject, _ := info.XMP["dc:subject"].([]interface{}); len(subject) != 2 || subject[0] != "sky" {
+		t.Errorf("Invalid XMP subject: %#v", info.XMP["dc:subject"])
+	}
+}
+
+func TestReadMetadataRedactGPS(t *testing.T) {
+	buf := jpegFixture(
+		jpegSegment(0xE1, append([]byte("Exif\x00\x00"), exifFixture()...)),
+		jpegSegment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmpFixture...)),
+	)
+
+	info := ReadMetadata(buf, true)
+
+	for key := range info.EXIF {
+		if key[:3] == "GPS" {
+			t.Errorf("GPS EXIF field must be redacted: %s", key)
+		}
+	}
+	if _, ok := info.XMP["exif:GPSLatitude"]; ok {
+		t.Error("GPS XMP field must be redacted")
+	}
+	if info.EXIF["Make"] != "Canon" {
+		t.Error("Non GPS fields must be preserved")
+	}
+}
+
+func TestReadMetadataRedactGPSCustomPrefix(t *testing.T) {
+	xmp := strings.Replace(xmpFixture, "exif:", "ns1:", -1)
+	xmp = strings.Replace(xmp, "xmlns:exif=", "xmlns:ns1=", -1)
+	buf := jpegFixture(jpegSegment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...)))
+
+	info := ReadMetadata(buf, true)
+
+	for key := range info.XMP {
+		if strings.Contains(key, "GPS") {
+			t.Errorf("GPS XMP field must be redacted: %s", key)
+		}
+	}
+	if info.XMP["xmp:CreateDate"] != "2017-01-02T03:04:05" {
+		t.Errorf("Non GPS fields must be preserved: %#v", info.XMP)
+	}
+}
+
+func TestReadMetadataInvalid(t *testing.T) {
+	cases := [][]byte{
+		nil,
+		[]byte("invalid"),
+		{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF},
+		jpegFixture(jpegSegment(0xE1, []byte("Exif\x00\x00II*\x00\xff\xff\xff\xff"))),
+	}
+
+	for _, buf := range cases {
+		info := ReadMetadata(buf, false)
+		if len(info.IPTC) != 0 || len(info.XMP) != 0 {
+			t.Errorf("Invalid metadata: %#v", info)
+		}
+	}
+}
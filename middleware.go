@@ -86,7 +86,7 @@ func validateImage(next http.Handler, o ServerOptions) http.Handler {
 			return
 		}
 
-		if r.Method == "GET" && o.Mount == "" && o.EnableURLSource == false {
+		if r.Method == "GET" && !acceptsGetRequests(o) {
 			ErrorReply(r, w, ErrMethodNotAllowed, o)
 			return
 		}
@@ -142,5 +142,5 @@ func getCacheControl(ttl int) string {
 }
 
 func isPublicPath(path string) bool {
-	return path == "/" || path == "/health" || path == "/form"
+	return path == "/" || path == "/health" || path == "/form" || path == "/openapi.json"
 }
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+const openapiVersion = "3.0.3"
+
+// openapiResponses defines the response MIME types of the operations not replying with an image
+var openapiResponses = map[string][]string{
+	"info":    {"application/json"},
+	"palette": {"application/json"},
+	"lqip":    {"application/json"},
+	"hash":    {"application/json"},
+	"srcset":  {"application/json", "multipart/mixed", "application/zip"},
+	"sheet":   {"image/*", "application/json"},
+}
+
+// openapiFormFields defines the additional image form fields accepted by each operation
+var openapiFormFields = map[string][]string{
+	"watermarkimage": {watermarkImageField},
+	"hash":           {compareImageField},
+	"pipeline":       {watermarkImageField},
+}
+
+// openapiFormParams defines the params which may be also defined as multipart form field, by operation
+var openapiFormParams = map[string][]string{
+	"pipeline": {"operations"},
+}
+
+type jsonObject map[string]interface{}
+
+func openapiController(o ServerOptions) func(http.ResponseWriter, *http.Request) {
+	body, _ := json.Marshal(OpenAPISpec(o))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+// OpenAPISpec creates the OpenAPI 3 document describing the endpoints registered by the server,
+// and the params allowed by each one, based on the given server options.
+func OpenAPISpec(o ServerOptions) jsonObject {
+	paths := jsonObject{
+		join(o, "/"):             openapiInfoPath("Versions", "#/components/schemas/Versions"),
+		join(o, "/health"):       openapiInfoPath("Server health stats", "#/components/schemas/Health"),
+		join(o, "/openapi.json"): openapiInfoPath("OpenAPI document", ""),
+	}
+
+	for _, route := range imageRoutes {
+		paths[join(o, "/"+route.Name)] = openapiImagePath(route.Name, o)
+		if supportsPathStyleURL(route.Name) && (o.PathOrigin != "" || isSourceEnabled(ImageSourceTypeFileSystem, o)) {
+			paths[join(o, "/"+route.Name)+"/{options}/{path}"] = openapiPathStylePath(route.Name)
+		}
+	}
+	paths[join(o, "/sheet")] = openapiImagePath("sheet", o)
+
+	spec := jsonObject{
+		"openapi": openapiVersion,
+		"info": jsonObject{
+			"title":       "imaginary",
+			"description": "HTTP microservice for high-level image processing",
+			"version":     Version,
+		},
+		"paths": paths,
+		"components": jsonObject{
+			"schemas": openapiSchemas(),
+		},
+	}
+
+	if o.ApiKey != "" {
+		spec["components"].(jsonObject)["securitySchemes"] = jsonObject{
+			"apiKeyHeader": jsonObject{"type": "apiKey", "in": "header", "name": "API-Key"},
+			"apiKeyQuery":  jsonObject{"type": "apiKey", "in": "query", "name": "key"},
+		}
+		spec["security"] = []jsonObject{{"apiKeyHeader": []string{}}, {"apiKeyQuery": []string{}}}
+	}
+
+	return spec
+}
+
+func openapiInfoPath(description, schema string) jsonObject {
+	content := jsonObject{"type": "object"}
+	if schema != "" {
+		content = jsonObject{"$ref": schema}
+	}
+
+	return jsonObject{
+		"get": jsonObject{
+			"responses": jsonObject{
+				"200": jsonObject{
+					"description": description,
+					"content":     jsonObject{"application/json": jsonObject{"schema": content}},
+				},
+				"default": openapiErrorResponse(),
+			},
+		},
+	}
+}
+
+func openapiImagePath(name string, o ServerOptions) jsonObject {
+	responses := jsonObject{
+		"200":     jsonObject{"description": "Processed image or operation result", "content": openapiContent(name)},
+		"default": openapiErrorResponse(),
+	}
+
+	params := openapiParams(name, o)
+	path := jsonObject{}
+	if isSourceEnabled(ImageSourceTypeBody, o) {
+		path["post"] = jsonObject{
+			"operationId": name,
+			"parameters":  params,
+			"requestBody": openapiRequestBody(name),
+			"responses":   responses,
+		}
+	}
+
+	// Images can only be read via GET from the mounted directory, remote URLs or object storage buckets
+	if acceptsGetRequests(o) {
+		path["get"] = jsonObject{
+			"operationId": name + "Get",
+			"parameters":  params,
+			"responses":   responses,
+		}
+	}
+
+	return path
+}
+
+// openapiPathStylePath describes the path-style URLs of the operation, such as /resize/w:300,h:200/path/to/image.jpg
+func openapiPathStylePath(name string) jsonObject {
+	return jsonObject{
+		"get": jsonObject{
+			"operationId": name + "Path",
+			"parameters": []jsonObject{
+				{
+					"name":        "options",
+					"in":          "path",
+					"required":    true,
+					"description": "Comma separated name:value params, such as w:300,h:200, or - if none",
+					"schema":      jsonObject{"type": "string"},
+				},
+				{
+					"name":        "path",
+					"in":          "path",
+					"required":    true,
+					"description": "Image path, resolved against the path origin or the mounted directory",
+					"schema":      jsonObject{"type": "string"},
+				},
+			},
+			"responses": jsonObject{
+				"200":     jsonObject{"description": "Processed image or operation result", "content": openapiContent(name)},
+				"default": openapiErrorResponse(),
+			},
+		},
+	}
+}
+
+func openapiContent(name string) jsonObject {
+	mimes, ok := openapiResponses[name]
+	if !ok {
+		mimes = []string{"image/*"}
+	}
+
+	content := jsonObject{}
+	for _, mime := range mimes {
+		if mime == "application/json" {
+			content[mime] = jsonObject{"schema": jsonObject{"type": "object"}}
+			continue
+		}
+		content[mime] = jsonObject{"schema": jsonObject{"type": "string", "format": "binary"}}
+	}
+	return content
+}
+
+func openapiRequestBody(name string) jsonObject {
+	file := jsonObject{"type": "string", "format": "binary"}
+	if name == "sheet" {
+		file = jsonObject{"type": "array", "items": file}
+	}
+
+	fields := jsonObject{formFieldName: file}
+	for _, field := range openapiFormFields[name] {
+		fields[field] = jsonObject{"type": "string", "format": "binary"}
+	}
+	for _, param := range openapiFormParams[name] {
+		fields[param] = jsonObject{"type": "string", "description": "Same as the " + param + " query param"}
+	}
+
+	content := jsonObject{
+		"image/*":             jsonObject{"schema": jsonObject{"type": "string", "format": "binary"}},
+		"multipart/form-data": jsonObject{"schema": jsonObject{"type": "object", "properties": fields}},
+	}
+
+	// Pipeline operations may be also defined as JSON body, reading the image from the query params
+	if name == "pipeline" {
+		content["application/json"] = jsonObject{"schema": openapiOperationsSchema()}
+	}
+
+	return jsonObject{"content": content}
+}
+
+func openapiOperationsSchema() jsonObject {
+	return jsonObject{"type": "array", "items": jsonObject{"$ref": "#/components/schemas/PipelineOperation"}}
+}
+
+func openapiErrorResponse() jsonObject {
+	return jsonObject{
+		"description": "Error",
+		"content": jsonObject{
+			"application/json": jsonObject{"schema": jsonObject{"$ref": "#/components/schemas/Error"}},
+		},
+	}
+}
+
+// openapiParams lists the query params of the given operation. Operations without
+// params rules, if any, allow every param.
+func openapiParams(name string, o ServerOptions) []jsonObject {
+	names := []string{}
+	if isSourceEnabled(ImageSourceTypeFileSystem, o) {
+		names = append(names, "file")
+	}
+	for _, kind := range []ImageSourceType{ImageSourceTypeHttp, ImageSourceTypeS3, ImageSourceTypeGCS} {
+		if isSourceEnabled(kind, o) {
+			names = append(names, "url")
+		}
+	}
+	if isSourceEnabled(ImageSourceTypeS3, o) {
+		names = append(names, "bucket", "object")
+	}
+	names = append(names, "field", "page", "n", "density", "dpr")
+
+	if rules, ok := operationParams[name]; ok {
+		names = append(names, rules.Allowed...)
+	} else {
+		all := []string{}
+		for param := range allowedParams {
+			all = append(all, param)
+		}
+		sort.Strings(all)
+		names = append(names, all...)
+	}
+
+	// Params which may be defined as form field are not required in the query
+	required := map[string]bool{}
+	for _, group := range operationParams[name].Required {
+		if len(group) == 1 && !containsString(openapiFormParams[name], group[0]) {
+			required[group[0]] = true
+		}
+	}
+
+	params := []jsonObject{}
+	seen := map[string]bool{}
+	for _, param := range names {
+		if seen[param] {
+			continue
+		}
+		seen[param] = true
+
+		spec := jsonObject{"name": param, "in": "query"}
+		if required[param] {
+			spec["required"] = true
+		}
+		if containsString(openapiFormParams[name], param) {
+			spec["description"] = "Required, unless defined as multipart form field or JSON body"
+		}
+
+		switch {
+		case allowedParams[param] == "json":
+			spec["content"] = jsonObject{"application/json": jsonObject{"schema": openapiOperationsSchema()}}
+		case allowedParams[param] == "intlist" || allowedParams[param] == "list":
+			spec["schema"] = openapiParamSchema(param)
+			spec["explode"] = false
+		case param == sheetCaptionField || (name == "sheet" && (param == "file" || param == "url")):
+			spec["schema"] = jsonObject{"type": "array", "items": openapiParamSchema(param)}
+		default:
+			spec["schema"] = openapiParamSchema(param)
+		}
+
+		params = append(params, spec)
+	}
+
+	return params
+}
+
+// openapiParamSchema creates the JSON schema of the given param, based on its kind, range and allowed values
+func openapiParamSchema(name string) jsonObject {
+	schema := jsonObject{"type": "string"}
+
+	switch allowedParams[name] {
+	case "int", "optint":
+		schema = jsonObject{"type": "integer"}
+	case "float":
+		schema = jsonObject{"type": "number"}
+	case "bool":
+		schema = jsonObject{"type": "boolean"}
+	case "color":
+		schema["pattern"] = `^\d{1,3},\d{1,3},\d{1,3}$`
+		schema["example"] = "255,200,150"
+	case "dimension":
+		schema["pattern"] = `^\d+(\.\d+)?p?$`
+		schema["example"] = "300"
+	case "aspect":
+		schema["pattern"] = `^\d+(\.\d+)?(:\d+(\.\d+)?)?$`
+		schema["example"] = "16:9"
+	case "quality":
+		return jsonObject{"oneOf": []jsonObject{
+			openapiRangeSchema(name, jsonObject{"type": "integer"}),
+			{"type": "string", "enum": []string{"auto"}},
+		}}
+	case "intlist":
+		return jsonObject{"type": "array", "items": openapiRangeSchema(name, jsonObject{"type": "integer"})}
+	case "list":
+		items := jsonObject{"type": "string"}
+		if enum, ok := paramEnums[name]; ok {
+			items["enum"] = enum
+		}
+		return jsonObject{"type": "array", "items": items}
+	}
+
+	if name == "url" {
+		schema["format"] = "uri"
+	}
+	if enum, ok := paramEnums[name]; ok {
+		schema["enum"] = enum
+	}
+
+	return openapiRangeSchema(name, schema)
+}
+
+// openapiRangeSchema defines the range of the numeric schemas of the given param, if any
+func openapiRangeSchema(name string, schema jsonObject) jsonObject {
+	if schema["type"] != "integer" && schema["type"] != "number" {
+		return schema
+	}
+	if r, ok := paramRanges[name]; ok {
+		schema["minimum"] = r.Min
+		if r.Max != unbounded {
+			schema["maximum"] = r.Max
+		}
+	}
+	return schema
+}
+
+func openapiSchemas() jsonObject {
+	operations := []string{}
+	for name := range OperationsMap {
+		operations = append(operations, name)
+	}
+	sort.Strings(operations)
+
+	return jsonObject{
+		"Error": jsonObject{
+			"type":     "object",
+			"required": []string{"code"},
+			"properties": jsonObject{
+				"message": jsonObject{"type": "string"},
+				"code":    jsonObject{"type": "integer"},
+				"errors":  jsonObject{"type": "array", "items": jsonObject{"$ref": "#/components/schemas/ParamError"}},
+			},
+		},
+		"ParamError": jsonObject{
+			"type":     "object",
+			"required": []string{"param", "message"},
+			"properties": jsonObject{
+				"param":   jsonObject{"type": "string"},
+				"value":   jsonObject{"type": "string"},
+				"message": jsonObject{"type": "string"},
+			},
+		},
+		"PipelineOperation": jsonObject{
+			"type":     "object",
+			"required": []string{"operation"},
+			"properties": jsonObject{
+				"operation": jsonObject{"type": "string", "enum": operations},
+				"params":    jsonObject{"type": "object"},
+			},
+		},
+		"Versions": jsonObject{
+			"type": "object",
+			"properties": jsonObject{
+				"imaginary": jsonObject{"type": "string"},
+				"bimg":      jsonObject{"type": "string"},
+				"libvips":   jsonObject{"type": "string"},
+			},
+		},
+		"Health": jsonObject{
+			"type": "object",
+			"properties": jsonObject{
+				"uptime":               jsonObject{"type": "integer"},
+				"allocatedMemory":      jsonObject{"type": "number"},
+				"totalAllocatedMemory": jsonObject{"type": "number"},
+				"goroutines":           jsonObject{"type": "integer"},
+				"cpus":                 jsonObject{"type": "integer"},
+			},
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	spec := OpenAPISpec(ServerOptions{PathPrefix: "/"})
+
+	paths := spec["paths"].(jsonObject)
+	for _, route := range imageRoutes {
+		if _, ok := paths["/"+route.Name]; !ok {
+			t.Errorf("Missing path: /%s", route.Name)
+		}
+	}
+	for _, path := range []string{"/", "/health", "/sheet"} {
+		if _, ok := paths[path]; !ok {
+			t.Errorf("Missing path: %s", path)
+		}
+	}
+
+	if _, ok := paths["/resize"].(jsonObject)["get"]; ok {
+		t.Error("GET operation must be defined only with image sources available via GET")
+	}
+
+	schemas := spec["components"].(jsonObject)["schemas"].(jsonObject)
+	if _, ok := schemas["Error"]; !ok {
+		t.Error("Missing Error schema")
+	}
+	if _, ok := spec["security"]; ok {
+		t.Error("Security must be defined only with API key")
+	}
+
+	if _, err := json.Marshal(spec); err != nil {
+		t.Fatalf("Cannot encode the spec: %s", err)
+	}
+}
+
+func TestOpenAPISpecParams(t *testing.T) {
+	spec := OpenAPISpec(ServerOptions{PathPrefix: "/", Mount: "/images", ApiKey: "secret"})
+	operation := spec["paths"].(jsonObject)["/resize"].(jsonObject)["get"].(jsonObject)
+
+	params := map[string]jsonObject{}
+	for _, param := range operation["parameters"].([]jsonObject) {
+		params[param["name"].(string)] = param
+	}
+
+	if _, ok := params["file"]; !ok {
+		t.Error("Missing file param")
+	}
+	if _, ok := params["text"]; ok {
+		t.Error("Param not allowed in resize operation: text")
+	}
+
+	for _, name := range []string{"gravity", "extend", "colorspace", "type"} {
+		schema := params[name]["schema"].(jsonObject)
+		if enum, ok := schema["enum"].([]string); !ok || len(enum) == 0 {
+			t.Errorf("Missing %s param enum", name)
+		}
+	}
+
+	compression := params["compression"]["schema"].(jsonObject)
+	if compression["type"] != "integer" || compression["minimum"] != float64(0) || compression["maximum"] != float64(9) {
+		t.Errorf("Invalid compression param schema: %#v", compression)
+	}
+
+	width := params["width"]["schema"].(jsonObject)
+	if _, ok := width["minimum"]; ok || width["type"] != "string" {
+		t.Errorf("Invalid width param schema: %#v", width)
+	}
+
+	quality := params["quality"]["schema"].(jsonObject)
+	if len(quality["oneOf"].([]jsonObject)) != 2 {
+		t.Errorf("Invalid quality param schema: %#v", quality)
+	}
+
+	if _, ok := spec["security"]; !ok {
+		t.Error("Missing security requirement")
+	}
+
+	convert := spec["paths"].(jsonObject)["/convert"].(jsonObject)["post"].(jsonObject)
+	for _, param := range convert["parameters"].([]jsonObject) {
+		if param["name"] == "type" && param["required"] != true {
+			t.Error("Param type must be required in convert operation")
+		}
+	}
+
+	if _, ok := spec["paths"].(jsonObject)["/pipeline/{options}/{path}"]; ok {
+		t.Error("Pipeline must not support path-style URLs")
+	}
+	if _, ok := spec["paths"].(jsonObject)["/resize/{options}/{path}"]; !ok {
+		t.Error("Missing resize path-style URL")
+	}
+
+	pipeline := spec["paths"].(jsonObject)["/pipeline"].(jsonObject)["post"].(jsonObject)
+	for _, param := range pipeline["parameters"].([]jsonObject) {
+		if param["name"] == "operations" && param["required"] == true {
+			t.Error("Param operations must not be required in the query, since it can be defined as form field")
+		}
+	}
+	content := pipeline["requestBody"].(jsonObject)["content"].(jsonObject)
+	fields := content["multipart/form-data"].(jsonObject)["schema"].(jsonObject)["properties"].(jsonObject)
+	if _, ok := fields["operations"]; !ok {
+		t.Error("Missing operations form field")
+	}
+	if _, ok := content["application/json"]; !ok {
+		t.Error("Missing operations JSON body")
+	}
+}
@@ -1,6 +1,16 @@
 package main
 
-import "gopkg.in/h2non/bimg.v1"
+import (
+	"math"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+const (
+	maxDPR = 4
+	// defaultTextDPI is the libvips default resolution used to render text
+	defaultTextDPI = 75
+)
 
 // ImageOptions represent all the supported image transformation params as first level members
 type ImageOptions struct {
@@ -10,13 +20,23 @@ type ImageOptions struct {
 	AreaHeight  int
 	Quality     int
 	Compression int
-	Rotate      int
+	MaxBytes    int
+	Effort      int
 	Top         int
 	Left        int
 	Margin      int
 	Factor      int
 	DPI         int
 	TextWidth   int
+	Radius      int
+	Colors      int
+	Threshold   *int
+	Columns     int
+	Gap         int
+	Page        int
+	Pages       int
+	XComponents int
+	YComponents int
 	Flip        bool
 	Flop        bool
 	Force       bool
@@ -25,15 +45,67 @@ type ImageOptions struct {
 	NoReplicate bool
 	NoRotation  bool
 	NoProfile   bool
+	Tile        bool
+	Inscribe    bool
+	AutoQuality bool
 	Opacity     float32
+	Sigma       float64
+	MinAmpl     float64
+	Flat        float64
+	Jagged      float64
+	Scale       float64
+	Rotate      float64
+	Density     float64
+	DPR         float64
+	Aspect      float64
 	Text        string
 	Font        string
 	Type        string
+	Image       string
+	Metadata    string
+	Kind        string
+	Compare     string
+	Algorithm   string
+	Output      string
+	Subsampling string
 	Color       []uint8
 	Extend      bimg.Extend
 	Gravity     bimg.Gravity
 	Colorspace  bimg.Interpretation
 	Background  []uint8
+	Operations  []PipelineOperation
+	Widths      []int
+	Types       []string
+
+	// Encoder params, applied only to the output image types supporting them
+	Progressive  bool
+	Lossless     bool
+	NearLossless bool
+	Palette      bool
+	Strip        bool
+	// PaletteColors defines the maximum number of colors of the PNG palette
+	PaletteColors int
+
+	// WidthPercent and HeightPercent define the dimensions relative to the source image
+	WidthPercent  float64
+	HeightPercent float64
+
+	// WatermarkBuffer stores the watermark image loaded from the image param or form field
+	WatermarkBuffer []byte
+	// CompareBuffer stores the image to compare with, loaded from the compare param or form field
+	CompareBuffer []byte
+	// Captions stores the sheet tiles captions, in the same order as the images
+	Captions []string
+	// MaxOutputDimension limits the output image dimensions, as defined by the server
+	MaxOutputDimension int
+	// RedactGPS removes the GPS fields from the image metadata, as defined by the server
+	RedactGPS bool
+}
+
+// PipelineOperation represents a single image operation step to be chained in a pipeline
+type PipelineOperation struct {
+	Name   string                 `json:"operation"`
+	Params map[string]interface{} `json:"params"`
 }
 
 // BimgOptions creates a new bimg compatible options struct mapping the fields properly
@@ -45,15 +117,31 @@ func BimgOptions(o ImageOptions) bimg.Options {
 		Flop:           o.Flop,
 		Quality:        o.Quality,
 		Compression:    o.Compression,
+		Speed:          encoderSpeed(o.Effort),
 		NoAutoRotate:   o.NoRotation,
 		NoProfile:      o.NoProfile,
+		Interlace:      o.Progressive,
+		Lossless:       o.Lossless,
+		Palette:        o.Palette,
+		StripMetadata:  o.Strip,
 		Force:          o.Force,
 		Gravity:        o.Gravity,
 		Embed:          o.Embed,
 		Extend:         o.Extend,
 		Interpretation: o.Colorspace,
 		Type:           ImageType(o.Type),
-		Rotate:         bimg.Angle(o.Rotate),
+		Rotate:         bimg.Angle(int(normalizeAngle(o.Rotate))),
+	}
+
+	if o.Sigma > 0 || o.MinAmpl > 0 {
+		opts.GaussianBlur = bimg.GaussianBlur{
+			Sigma:   o.Sigma,
+			MinAmpl: o.MinAmpl,
+		}
+	}
+
+	if o.Radius > 0 {
+		opts.Sharpen = sharpenOptions(o)
 	}
 
 	if len(o.Background) != 0 {
@@ -62,3 +150,129 @@ func BimgOptions(o ImageOptions) bimg.Options {
 
 	return opts
 }
+
+// encoderSpeed maps the encoding effort, from 1 (fastest) to 9 (smallest output),
+// to the libvips AVIF/HEIF encoder speed. Zero effort keeps the encoder default.
+func encoderSpeed(effort int) int {
+	if effort == 0 {
+		return 0
+	}
+	if effort > 9 {
+		effort = 9
+	}
+	return 9 - effort
+}
+
+// sharpenOptions creates the bimg sharpen options, using the libvips
+// defaults for the jagged slope and the fixed thresholds.
+func sharpenOptions(o ImageOptions) bimg.Sharpen {
+	sharpen := bimg.Sharpen{
+		Radius: o.Radius,
+		X1:     2,
+		Y2:     10,
+		Y3:     20,
+		M1:     o.Flat,
+		M2:     o.Jagged,
+	}
+
+	if sharpen.M2 == 0 {
+		sharpen.M2 = 3
+	}
+
+	return sharpen
+}
+
+// ApplyDPR scales the absolute pixel params, such as dimensions, areas, margins or the text size,
+// by the given device pixel ratio
+func ApplyDPR(o ImageOptions, dpr float64) ImageOptions {
+	if dpr <= 1 {
+		return o
+	}
+
+	scale := func(value int) int {
+		return int(math.Floor(float64(value)*dpr + 0.5))
+	}
+
+	o.Width = scale(o.Width)
+	o.Height = scale(o.Height)
+	o.AreaWidth = scale(o.AreaWidth)
+	o.AreaHeight = scale(o.AreaHeight)
+	o.Top = scale(o.Top)
+	o.Left = scale(o.Left)
+	o.Margin = scale(o.Margin)
+	o.TextWidth = scale(o.TextWidth)
+
+	// Text size depends on the rendering resolution
+	if o.DPI == 0 {
+		o.DPI = defaultTextDPI
+	}
+	o.DPI = scale(o.DPI)
+
+	return o
+}
+
+// maxServerDPR returns the maximum device pixel ratio allowed by the server
+func maxServerDPR(o ServerOptions) float64 {
+	if o.MaxDPR > 0 && o.MaxDPR < maxDPR {
+		return o.MaxDPR
+	}
+	return maxDPR
+}
+
+// hasRelativeParams reports whether the options depend on the source image size
+func hasRelativeParams(o ImageOptions) bool {
+	return o.WidthPercent > 0 || o.HeightPercent > 0 || o.Aspect > 0
+}
+
+// ResolveRelativeParams calculates the absolute dimensions from the percentages of the given source
+// image size, and the missing dimension, or the largest centered crop box, from the aspect ratio.
+// If area params are defined, the aspect ratio applies to the extracted area instead.
+func ResolveRelativeParams(o ImageOptions, width, height int) (ImageOptions, error) {
+	round := func(value float64) int {
+		return int(math.Floor(value + 0.5))
+	}
+
+	if o.WidthPercent > 0 {
+		if o.Width > 0 {
+			return o, NewError("Invalid params: width cannot be defined in pixels and percentage", BadRequest)
+		}
+		o.Width = round(float64(width) * o.WidthPercent / 100)
+	}
+	if o.HeightPercent > 0 {
+		if o.Height > 0 {
+			return o, NewError("Invalid params: height cannot be defined in pixels and percentage", BadRequest)
+		}
+		o.Height = round(float64(height) * o.HeightPercent / 100)
+	}
+
+	if o.Aspect == 0 {
+		return o, nil
+	}
+
+	if o.AreaWidth > 0 || o.AreaHeight > 0 {
+		if o.AreaWidth > 0 && o.AreaHeight > 0 {
+			return o, NewError("Invalid params: aspect cannot be used along with both areawidth and areaheight", BadRequest)
+		}
+		if o.AreaWidth > 0 {
+			o.AreaHeight = round(float64(o.AreaWidth) / o.Aspect)
+		} else {
+			o.AreaWidth = round(float64(o.AreaHeight) * o.Aspect)
+		}
+		return o, nil
+	}
+
+	switch {
+	case o.Width > 0 && o.Height > 0:
+		return o, NewError("Invalid params: aspect cannot be used along with both width and height", BadRequest)
+	case o.Width > 0:
+		o.Height = round(float64(o.Width) / o.Aspect)
+	case o.Height > 0:
+		o.Width = round(float64(o.Height) * o.Aspect)
+	case float64(width)/float64(height) > o.Aspect:
+		o.Width, o.Height = round(float64(height)*o.Aspect), height
+	default:
+		o.Width, o.Height = width, round(float64(width)/o.Aspect)
+	}
+
+	return o, nil
+}
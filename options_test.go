@@ -13,3 +13,119 @@ func TestBimgOptions(t *testing.T) {
 		t.Error("Invalid width and height")
 	}
 }
+
+func TestBimgOptionsEffects(t *testing.T) {
+	opts := BimgOptions(ImageOptions{Sigma: 5, MinAmpl: 0.2, Radius: 2, Flat: 1})
+
+	if opts.GaussianBlur.Sigma != 5 || opts.GaussianBlur.MinAmpl != 0.2 {
+		t.Errorf("Invalid gaussian blur options: %#v", opts.GaussianBlur)
+	}
+	if opts.Sharpen.Radius != 2 || opts.Sharpen.M1 != 1 || opts.Sharpen.M2 != 3 || opts.Sharpen.Y3 == 0 {
+		t.Errorf("Invalid sharpen options: %#v", opts.Sharpen)
+	}
+
+	opts = BimgOptions(ImageOptions{})
+	if opts.GaussianBlur.Sigma != 0 || opts.Sharpen.Radius != 0 {
+		t.Error("Effects must not be enabled by default")
+	}
+}
+
+func TestEncoderSpeed(t *testing.T) {
+	cases := []struct {
+		effort   int
+		expected int
+	}{
+		{0, 0},
+		{1, 8},
+		{5, 4},
+		{9, 0},
+		{20, 0},
+	}
+
+	for _, test := range cases {
+		if speed := encoderSpeed(test.effort); speed != test.expected {
+			t.Errorf("Invalid encoder speed: %d != %d", speed, test.expected)
+		}
+	}
+}
+
+func TestApplyDPR(t *testing.T) {
+	opts := ApplyDPR(ImageOptions{Width: 300, Height: 101, AreaWidth: 50, Top: 10, Margin: 5, TextWidth: 100}, 2)
+
+	if opts.Width != 600 || opts.Height != 202 || opts.AreaWidth != 100 || opts.AreaHeight != 0 {
+		t.Errorf("Invalid scaled dimensions: %#v", opts)
+	}
+	if opts.Top != 20 || opts.Margin != 10 || opts.TextWidth != 200 {
+		t.Errorf("Invalid scaled position: %#v", opts)
+	}
+	if opts.DPI != 2*defaultTextDPI {
+		t.Errorf("Invalid scaled text resolution: %d", opts.DPI)
+	}
+
+	if opts := ApplyDPR(ImageOptions{Width: 300}, 1); opts.Width != 300 || opts.DPI != 0 {
+		t.Errorf("Options must not be scaled: %#v", opts)
+	}
+}
+
+func TestMaxServerDPR(t *testing.T) {
+	cases := []struct {
+		max      float64
+		expected float64
+	}{
+		{0, 4},
+		{2, 2},
+		{8, 4},
+	}
+
+	for _, test := range cases {
+		if dpr := maxServerDPR(ServerOptions{MaxDPR: test.max}); dpr != test.expected {
+			t.Errorf("Invalid max DPR: %f != %f", dpr, test.expected)
+		}
+	}
+}
+
+func TestResolveRelativeParams(t *testing.T) {
+	cases := []struct {
+		opts     ImageOptions
+		width    int
+		height   int
+		areaSize [2]int
+	}{
+		{ImageOptions{WidthPercent: 50}, 960, 0, [2]int{}},
+		{ImageOptions{WidthPercent: 50, HeightPercent: 25}, 960, 270, [2]int{}},
+		{ImageOptions{Width: 320, Aspect: 16.0 / 9}, 320, 180, [2]int{}},
+		{ImageOptions{Height: 180, Aspect: 16.0 / 9}, 320, 180, [2]int{}},
+		{ImageOptions{HeightPercent: 50, Aspect: 1}, 540, 540, [2]int{}},
+		{ImageOptions{Aspect: 1}, 1080, 1080, [2]int{}},
+		{ImageOptions{Aspect: 4}, 1920, 480, [2]int{}},
+		{ImageOptions{AreaWidth: 400, Aspect: 2}, 0, 0, [2]int{400, 200}},
+	}
+
+	for _, test := range cases {
+		opts, err := ResolveRelativeParams(test.opts, 1920, 1080)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.Width != test.width || opts.Height != test.height {
+			t.Errorf("Invalid dimensions for %#v: %dx%d", test.opts, opts.Width, opts.Height)
+		}
+		if opts.AreaWidth != test.areaSize[0] || opts.AreaHeight != test.areaSize[1] {
+			t.Errorf("Invalid area for %#v: %dx%d", test.opts, opts.AreaWidth, opts.AreaHeight)
+		}
+	}
+}
+
+func TestResolveRelativeParamsErrors(t *testing.T) {
+	cases := []ImageOptions{
+		{Width: 100, WidthPercent: 50},
+		{Height: 100, HeightPercent: 50},
+		{Width: 100, Height: 100, Aspect: 1},
+		{AreaWidth: 100, AreaHeight: 100, Aspect: 1},
+	}
+
+	for _, opts := range cases {
+		if _, err := ResolveRelativeParams(opts, 1920, 1080); err == nil {
+			t.Errorf("Invalid params must fail: %#v", opts)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// supportsPages reports whether the image type can have multiple pages or frames
+func supportsPages(kind bimg.ImageType) bool {
+	return kind == bimg.PDF || kind == bimg.TIFF || kind == bimg.GIF || kind == bimg.WEBP || kind == bimg.HEIF
+}
+
+// supportsPageStacking reports whether multiple pages of the image type can be stacked in a single image,
+// which is the case of document pages, but not of animation frames, such as animated GIF or WEBP
+func supportsPageStacking(kind bimg.ImageType) bool {
+	return kind == bimg.PDF || kind == bimg.TIFF || kind == bimg.HEIF
+}
+
+// supportsDensity reports whether the image type is a vector format rasterized at a given density
+func supportsDensity(kind bimg.ImageType) bool {
+	return kind == bimg.PDF || kind == bimg.SVG
+}
+
+// pageLoaderOptions returns the libvips loader options to select the pages and density,
+// clamping the number of pages to the available ones.
+func pageLoaderOptions(page, n, pages int, density float64) (string, error) {
+	options := []string{}
+
+	if page > 0 || n > 0 {
+		if page >= pages {
+			return "", fmt.Errorf("Invalid param: page must be lower than %d", pages)
+		}
+		if n == 0 {
+			n = 1
+		}
+		if page+n > pages {
+			n = pages - page
+		}
+		options = append(options, fmt.Sprintf("page=%d", page), fmt.Sprintf("n=%d", n))
+	}
+
+	if density > 0 {
+		options = append(options, fmt.Sprintf("dpi=%g", density))
+	}
+
+	return strings.Join(options, ","), nil
+}
+
+// loadedPagesSize calculates the size of the image loaded by LoadImagePages from its header,
+// without loading it, scaling it by the density and stacking the selected pages, assuming
+// that every page has the same size as the first one.
+func loadedPagesSize(buf []byte, o ImageOptions) (int, int, error) {
+	kind := bimg.DetermineImageType(buf)
+
+	width, height, err := readImageSize(buf)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	scale := 1.0
+	if o.Density > 0 && supportsDensity(kind) {
+		// Vector images are rasterized at 72 DPI by default
+		scale = o.Density / 72
+	}
+
+	n := 1
+	if o.Pages > 1 && supportsPageStacking(kind) {
+		pages, err := vipsImagePages(buf)
+		if err != nil {
+			return 0, 0, err
+		}
+		n = int(math.Max(1, math.Min(float64(o.Pages), float64(pages-o.Page))))
+	}
+
+	return clampSize(float64(width) * scale), clampSize(float64(height) * scale * float64(n)), nil
+}
+
+// LoadImagePages selects the pages of multi-page images, such as PDF, TIFF or animated GIF,
+// and rasterizes vector images, such as PDF or SVG, at the given density.
+// Multiple pages of documents, such as PDF, TIFF or HEIF, are stacked vertically, while animated
+// images only allow to select a single frame. The result image is losslessly encoded as PNG.
+func LoadImagePages(buf []byte, o ImageOptions) ([]byte, error) {
+	kind := bimg.DetermineImageType(buf)
+	name := bimg.DetermineImageTypeName(buf)
+
+	if o.Page < 0 || o.Pages < 0 {
+		return nil, fmt.Errorf("Invalid params: page and n must be positive")
+	}
+
+	pages := 1
+	if o.Page > 0 || o.Pages > 0 {
+		if !supportsPages(kind) {
+			return nil, fmt.Errorf("Page selection is not supported for %s images", name)
+		}
+		if o.Pages > 1 && !supportsPageStacking(kind) {
+			return nil, fmt.Errorf("Multiple pages are not supported for %s images, only a single frame can be selected", name)
+		}
+
+		var err error
+		if pages, err = vipsImagePages(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Density > 0 && !supportsDensity(kind) {
+		return nil, fmt.Errorf("Density is not supported for %s images", name)
+	}
+
+	options, err := pageLoaderOptions(o.Page, o.Pages, pages, o.Density)
+	if err != nil {
+		return nil, err
+	}
+
+	return vipsLoadBuffer(buf, options)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+func TestPageLoaderOptions(t *testing.T) {
+	cases := []struct {
+		page    int
+		n       int
+		pages   int
+		density float64
+		options string
+	}{
+		{0, 0, 1, 0, ""},
+		{2, 0, 5, 0, "page=2,n=1"},
+		{1, 3, 5, 0, "page=1,n=3"},
+		{3, 10, 5, 0, "page=3,n=2"},
+		{0, 0, 1, 150, "dpi=150"},
+		{1, 1, 2, 72.5, "page=1,n=1,dpi=72.5"},
+	}
+
+	for _, test := range cases {
+		options, err := pageLoaderOptions(test.page, test.n, test.pages, test.density)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if options != test.options {
+			t.Errorf("Invalid loader options: %s != %s", options, test.options)
+		}
+	}
+
+	if _, err := pageLoaderOptions(5, 0, 5, 0); err == nil {
+		t.Error("Out of range page must fail")
+	}
+}
+
+func TestSupportsPages(t *testing.T) {
+	if !supportsPages(bimg.PDF) || !supportsPages(bimg.TIFF) || !supportsPages(bimg.GIF) {
+		t.Error("Multi-page image types must support pages")
+	}
+	if supportsPages(bimg.JPEG) || supportsPages(bimg.PNG) {
+		t.Error("Single page image types must not support pages")
+	}
+	if !supportsPageStacking(bimg.PDF) || !supportsPageStacking(bimg.TIFF) || supportsPageStacking(bimg.GIF) || supportsPageStacking(bimg.WEBP) {
+		t.Error("Only document pages must be stacked")
+	}
+	if !supportsDensity(bimg.SVG) || supportsDensity(bimg.TIFF) {
+		t.Error("Invalid density support")
+	}
+}
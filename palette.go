@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+const (
+	paletteSampleSize    = 100
+	paletteDefaultColors = 5
+	paletteMaxColors     = 32
+)
+
+// ImagePalette represents the image color palette details
+type ImagePalette struct {
+	Dominant  PaletteColor   `json:"dominant"`
+	Average   PaletteColor   `json:"average"`
+	Luminance float64        `json:"luminance"`
+	Palette   []PaletteColor `json:"palette"`
+}
+
+// PaletteColor represents a palette color and its population weight
+type PaletteColor struct {
+	Hex    string  `json:"hex"`
+	RGB    [3]int  `json:"rgb"`
+	Weight float64 `json:"weight,omitempty"`
+}
+
+func newPaletteColor(r, g, b float64, weight float64) PaletteColor {
+	rgb := [3]int{round(r), round(g), round(b)}
+	return PaletteColor{
+		Hex:    fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2]),
+		RGB:    rgb,
+		Weight: toFixed(weight, 4),
+	}
+}
+
+// GetImagePalette extracts the color palette with the given number of colors using median cut
+// quantization, ignoring transparent pixels. Colors are sorted by population weight.
+func GetImagePalette(img image.Image, colors int) ImagePalette {
+	pixels := readOpaquePixels(img)
+	if len(pixels) == 0 {
+		return ImagePalette{Palette: []PaletteColor{}}
+	}
+
+	var r, g, b float64
+	for _, pixel := range pixels {
+		r += float64(pixel[0])
+		g += float64(pixel[1])
+		b += float64(pixel[2])
+	}
+	total := float64(len(pixels))
+	r, g, b = r/total, g/total, b/total
+
+	palette := ImagePalette{
+		Average:   newPaletteColor(r, g, b, 0),
+		Luminance: toFixed(relativeLuminance(r, g, b), 4),
+		Palette:   []PaletteColor{},
+	}
+
+	for _, box := range medianCut(pixels, colors) {
+		palette.Palette = append(palette.Palette, box.color(total))
+	}
+	sort.Stable(byWeight(palette.Palette))
+	palette.Dominant = palette.Palette[0]
+
+	return palette
+}
+
+func readOpaquePixels(img image.Image) [][3]uint8 {
+	bounds := img.Bounds()
+	pixels := make([][3]uint8, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+			// Revert alpha premultiplication
+			r, g, b = r*0xffff/a, g*0xffff/a, b*0xffff/a
+			pixels = append(pixels, [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)})
+		}
+	}
+	return pixels
+}
+
+// relativeLuminance calculates the relative luminance of a sRGB color, between 0 and 1
+func relativeLuminance(r, g, b float64) float64 {
+	linear := func(c float64) float64 {
+		c = c / 255
+		if c <= 0.03928 {
+			return c / 12.92
+		}
+		return math.Pow((c+0.055)/1.055, 2.4)
+	}
+	return 0.2126*linear(r) + 0.7152*linear(g) + 0.0722*linear(b)
+}
+
+type colorBox [][3]uint8
+
+// byChannel sorts the box pixels by the given channel value
+type byChannel struct {
+	box     colorBox
+	channel int
+}
+
+func (s byChannel) Len() int           { return len(s.box) }
+func (s byChannel) Swap(i, j int)      { s.box[i], s.box[j] = s.box[j], s.box[i] }
+func (s byChannel) Less(i, j int) bool { return s.box[i][s.channel] < s.box[j][s.channel] }
+
+// byWeight sorts the palette colors by descending population weight
+type byWeight []PaletteColor
+
+func (s byWeight) Len() int           { return len(s) }
+func (s byWeight) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byWeight) Less(i, j int) bool { return s[i].Weight > s[j].Weight }
+
+// channelRange returns the channel with the widest range of values and its range
+func (b colorBox) channelRange() (int, int) {
+	channel, width := 0, -1
+	for c := 0; c < 3; c++ {
+		min, max := 255, 0
+		for _, pixel := range b {
+			value := int(pixel[c])
+			if value < min {
+				min = value
+			}
+			if value > max {
+				max = value
+			}
+		}
+		if max-min > width {
+			channel, width = c, max-min
+		}
+	}
+	return channel, width
+}
+
+func (b colorBox) color(total float64) PaletteColor {
+	var r, g, bl float64
+	for _, pixel := range b {
+		r += float64(pixel[0])
+		g += float64(pixel[1])
+		bl += float64(pixel[2])
+	}
+	count := float64(len(b))
+	return newPaletteColor(r/count, g/count, bl/count, count/total)
+}
+
+// medianCut splits the pixels in up to the given number of boxes, splitting each time
+// the box with the widest channel range by its median value.
+func medianCut(pixels [][3]uint8, colors int) []colorBox {
+	boxes := []colorBox{colorBox(pixels)}
+
+	for len(boxes) < colors {
+		index, channel, width := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if c, w := box.channelRange(); w > width {
+				index, channel, width = i, c, w
+			}
+		}
+		// No more boxes can be split
+		if index == -1 {
+			break
+		}
+
+		box := boxes[index]
+		sort.Sort(byChannel{box, channel})
+
+		// Split by the median value, keeping pixels with the same value in the same box
+		median := box[len(box)/2][channel]
+		split := sort.Search(len(box), func(i int) bool { return box[i][channel] >= median })
+		if split == 0 {
+			split = sort.Search(len(box), func(i int) bool { return box[i][channel] > median })
+		}
+
+		boxes[index] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	return boxes
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestGetImagePalette(t *testing.T) {
+	// 3/4 red and 1/4 blue image, with a transparent row to be ignored
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 5))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			c := color.NRGBA{255, 0, 0, 255}
+			if x == 3 {
+				c = color.NRGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	for x := 0; x < 4; x++ {
+		img.Set(x, 4, color.NRGBA{0, 255, 0, 0})
+	}
+
+	palette := GetImagePalette(img, 4)
+
+	if len(palette.Palette) != 2 {
+		t.Fatalf("Invalid palette length: %d", len(palette.Palette))
+	}
+	if palette.Dominant.Hex != "#ff0000" || palette.Dominant.Weight != 0.75 {
+		t.Errorf("Invalid dominant color: %#v", palette.Dominant)
+	}
+	if palette.Palette[1].Hex != "#0000ff" || palette.Palette[1].Weight != 0.25 {
+		t.Errorf("Invalid palette color: %#v", palette.Palette[1])
+	}
+	if palette.Average.RGB != [3]int{191, 0, 64} {
+		t.Errorf("Invalid average color: %#v", palette.Average)
+	}
+	if palette.Luminance <= 0 || palette.Luminance >= 1 {
+		t.Errorf("Invalid luminance: %f", palette.Luminance)
+	}
+}
+
+func TestGetImagePaletteTransparent(t *testing.T) {
+	palette := GetImagePalette(image.NewNRGBA(image.Rect(0, 0, 2, 2)), 5)
+	if len(palette.Palette) != 0 {
+		t.Errorf("Invalid palette length: %d", len(palette.Palette))
+	}
+}
+
+func TestRelativeLuminance(t *testing.T) {
+	if value := relativeLuminance(0, 0, 0); value != 0 {
+		t.Errorf("Invalid black luminance: %f", value)
+	}
+	if value := toFixed(relativeLuminance(255, 255, 255), 4); value != 1 {
+		t.Errorf("Invalid white luminance: %f", value)
+	}
+}
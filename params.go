@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -10,20 +14,39 @@ import (
 )
 
 var allowedParams = map[string]string{
-	"width":       "int",
-	"height":      "int",
-	"quality":     "int",
+	"width":       "dimension",
+	"height":      "dimension",
+	"quality":     "quality",
 	"top":         "int",
 	"left":        "int",
 	"areawidth":   "int",
 	"areaheight":  "int",
 	"compression": "int",
-	"rotate":      "int",
+	"maxbytes":    "int",
+	"effort":      "int",
 	"margin":      "int",
 	"factor":      "int",
 	"dpi":         "int",
 	"textwidth":   "int",
+	"radius":      "int",
+	"colors":      "int",
+	"threshold":   "optint",
+	"columns":     "int",
+	"gap":         "int",
+	"page":        "int",
+	"n":           "int",
+	"xcomponents": "int",
+	"ycomponents": "int",
+	"rotate":      "float",
 	"opacity":     "float",
+	"sigma":       "float",
+	"minampl":     "float",
+	"flat":        "float",
+	"jagged":      "float",
+	"scale":       "float",
+	"density":     "float",
+	"dpr":         "float",
+	"aspect":      "aspect",
 	"flip":        "bool",
 	"flop":        "bool",
 	"nocrop":      "bool",
@@ -32,31 +55,97 @@ var allowedParams = map[string]string{
 	"noreplicate": "bool",
 	"force":       "bool",
 	"embed":       "bool",
+	"tile":        "bool",
+	"inscribe":    "bool",
 	"text":        "string",
 	"font":        "string",
 	"type":        "string",
+	"image":       "string",
+	"metadata":    "string",
+	"kind":        "string",
+	"compare":     "string",
+	"algorithm":   "string",
 	"color":       "color",
 	"colorspace":  "colorspace",
 	"gravity":     "gravity",
 	"background":  "color",
 	"extend":      "extend",
+	"operations":  "json",
+	"widths":      "intlist",
+	"types":       "list",
+	"output":      "string",
+	"subsampling": "string",
+
+	// Encoder params
+	"progressive":   "bool",
+	"lossless":      "bool",
+	"nearlossless":  "bool",
+	"palette":       "bool",
+	"palettecolors": "int",
+	"strip":         "bool",
 }
 
-func readParams(query url.Values) ImageOptions {
+// readParams reads the image options from the query params. It returns an error per invalid
+// or out of range param, whose value is ignored or preserved, respectively, if the errors are not handled.
+func readParams(query url.Values) (ImageOptions, []ParamError) {
 	params := make(map[string]interface{})
+	paramErrors := []ParamError{}
 
 	for key, kind := range allowedParams {
 		param := query.Get(key)
-		params[key] = parseParam(param, kind)
+		value, err := parseParam(param, kind)
+		if err == nil && param != "" {
+			err = checkParamValue(key, param, value)
+		}
+		if err != nil {
+			paramErrors = append(paramErrors, ParamError{Param: key, Value: param, Message: err.Error()})
+		}
+		params[key] = value
+	}
+
+	sort.Sort(paramErrorsByName(paramErrors))
+	return mapImageParams(params), paramErrors
+}
+
+// readMapParams reads the image options from a generic params map, such as the ones
+// defined per step in a pipeline operation, returning an error per invalid param.
+func readMapParams(params map[string]interface{}) (ImageOptions, []ParamError) {
+	query, err := mapParamsValues(params)
+	if err != nil {
+		return ImageOptions{}, []ParamError{{Param: "params", Message: err.Error()}}
+	}
+	return readParams(query)
+}
+
+// mapParamsValues converts a generic params map into query params
+func mapParamsValues(params map[string]interface{}) (url.Values, error) {
+	query := url.Values{}
+
+	for key, value := range params {
+		switch value := value.(type) {
+		case string:
+			query.Set(key, value)
+		case float64:
+			query.Set(key, strconv.FormatFloat(value, 'f', -1, 64))
+		case bool:
+			query.Set(key, strconv.FormatBool(value))
+		default:
+			return nil, fmt.Errorf("Invalid value for param: %s", key)
+		}
 	}
 
-	return mapImageParams(params)
+	return query, nil
 }
 
-func parseParam(param, kind string) interface{} {
+// parseParam parses the param value based on its kind. The zero value of the kind is returned
+// along with the error if the value is invalid.
+func parseParam(param, kind string) (interface{}, error) {
 	if kind == "int" {
 		return parseInt(param)
 	}
+	if kind == "optint" {
+		return parseOptionalInt(param)
+	}
 	if kind == "float" {
 		return parseFloat(param)
 	}
@@ -64,66 +153,304 @@ func parseParam(param, kind string) interface{} {
 		return parseColor(param)
 	}
 	if kind == "colorspace" {
-		return parseColorspace(param)
+		return parseColorspace(param), nil
 	}
 	if kind == "gravity" {
-		return parseGravity(param)
+		return parseGravity(param), nil
 	}
 	if kind == "bool" {
 		return parseBool(param)
 	}
 	if kind == "extend" {
-		return parseExtendMode(param)
+		return parseExtendMode(param), nil
+	}
+	if kind == "json" {
+		return parseJSONOperations(param)
+	}
+	if kind == "dimension" {
+		return parseDimension(param)
+	}
+	if kind == "aspect" {
+		return parseAspectRatio(param)
+	}
+	if kind == "quality" {
+		return parseQuality(param)
+	}
+	if kind == "intlist" {
+		return parseIntList(param)
 	}
-	return param
+	if kind == "list" {
+		return parseList(param), nil
+	}
+	return param, nil
+}
+
+// checkParamValue checks the parsed param value against the param range or allowed values, if any
+func checkParamValue(name, param string, value interface{}) error {
+	if r, ok := paramRanges[name]; ok {
+		for _, number := range paramNumbers(value) {
+			if number < r.Min || number > r.Max {
+				return errors.New(rangeMessage(r))
+			}
+		}
+	}
+
+	if enum, ok := paramEnums[name]; ok {
+		if list, ok := value.([]string); ok {
+			for _, item := range list {
+				if !containsString(enum, item) {
+					return errors.New("must be a comma separated list of: " + strings.Join(enum, ", "))
+				}
+			}
+		} else if !containsString(enum, strings.ToLower(strings.TrimSpace(param))) {
+			return errors.New("must be one of: " + strings.Join(enum, ", "))
+		}
+	}
+
+	return nil
+}
+
+// paramNumbers returns the numeric values of the parsed param value to check against its range
+func paramNumbers(value interface{}) []float64 {
+	switch value := value.(type) {
+	case int:
+		return []float64{float64(value)}
+	case *int:
+		if value != nil {
+			return []float64{float64(*value)}
+		}
+	case float64:
+		return []float64{value}
+	case dimension:
+		if value.Percent == 0 {
+			return []float64{float64(value.Pixels)}
+		}
+	case qualityParam:
+		if !value.Auto {
+			return []float64{float64(value.Value)}
+		}
+	case []int:
+		numbers := []float64{}
+		for _, item := range value {
+			numbers = append(numbers, float64(item))
+		}
+		return numbers
+	}
+	return nil
 }
 
 func mapImageParams(params map[string]interface{}) ImageOptions {
+	width := params["width"].(dimension)
+	height := params["height"].(dimension)
+	quality := params["quality"].(qualityParam)
+
 	return ImageOptions{
-		Width:       params["width"].(int),
-		Height:      params["height"].(int),
-		Top:         params["top"].(int),
-		Left:        params["left"].(int),
-		AreaWidth:   params["areawidth"].(int),
-		AreaHeight:  params["areaheight"].(int),
-		DPI:         params["dpi"].(int),
-		Quality:     params["quality"].(int),
-		TextWidth:   params["textwidth"].(int),
-		Compression: params["compression"].(int),
-		Rotate:      params["rotate"].(int),
-		Factor:      params["factor"].(int),
-		Color:       params["color"].([]uint8),
-		Text:        params["text"].(string),
-		Font:        params["font"].(string),
-		Type:        params["type"].(string),
-		Flip:        params["flip"].(bool),
-		Flop:        params["flop"].(bool),
-		Embed:       params["flop"].(bool),
-		NoCrop:      params["nocrop"].(bool),
-		Force:       params["force"].(bool),
-		NoReplicate: params["noreplicate"].(bool),
-		NoRotation:  params["norotation"].(bool),
-		NoProfile:   params["noprofile"].(bool),
-		Opacity:     float32(params["opacity"].(float64)),
-		Extend:      params["extend"].(bimg.Extend),
-		Gravity:     params["gravity"].(bimg.Gravity),
-		Colorspace:  params["colorspace"].(bimg.Interpretation),
-		Background:  params["background"].([]uint8),
-	}
-}
-
-func parseBool(val string) bool {
-	value, _ := strconv.ParseBool(val)
-	return value
-}
-
-func parseInt(param string) int {
-	return int(math.Floor(parseFloat(param) + 0.5))
-}
-
-func parseFloat(param string) float64 {
-	val, _ := strconv.ParseFloat(param, 64)
-	return math.Abs(val)
+		Width:         width.Pixels,
+		Height:        height.Pixels,
+		WidthPercent:  width.Percent,
+		HeightPercent: height.Percent,
+		Aspect:        params["aspect"].(float64),
+		Top:           params["top"].(int),
+		Left:          params["left"].(int),
+		AreaWidth:     params["areawidth"].(int),
+		AreaHeight:    params["areaheight"].(int),
+		DPI:           params["dpi"].(int),
+		Quality:       quality.Value,
+		AutoQuality:   quality.Auto,
+		MaxBytes:      params["maxbytes"].(int),
+		TextWidth:     params["textwidth"].(int),
+		Compression:   params["compression"].(int),
+		Effort:        params["effort"].(int),
+		Rotate:        params["rotate"].(float64),
+		Factor:        params["factor"].(int),
+		Color:         params["color"].([]uint8),
+		Text:          params["text"].(string),
+		Font:          params["font"].(string),
+		Type:          params["type"].(string),
+		Image:         params["image"].(string),
+		Metadata:      params["metadata"].(string),
+		Kind:          params["kind"].(string),
+		Compare:       params["compare"].(string),
+		Algorithm:     params["algorithm"].(string),
+		Flip:          params["flip"].(bool),
+		Flop:          params["flop"].(bool),
+		Embed:         params["embed"].(bool),
+		NoCrop:        params["nocrop"].(bool),
+		Force:         params["force"].(bool),
+		NoReplicate:   params["noreplicate"].(bool),
+		NoRotation:    params["norotation"].(bool),
+		NoProfile:     params["noprofile"].(bool),
+		Tile:          params["tile"].(bool),
+		Inscribe:      params["inscribe"].(bool),
+		Radius:        params["radius"].(int),
+		Colors:        params["colors"].(int),
+		Threshold:     params["threshold"].(*int),
+		Columns:       params["columns"].(int),
+		Gap:           params["gap"].(int),
+		Page:          params["page"].(int),
+		Pages:         params["n"].(int),
+		XComponents:   params["xcomponents"].(int),
+		YComponents:   params["ycomponents"].(int),
+		Opacity:       float32(params["opacity"].(float64)),
+		Sigma:         params["sigma"].(float64),
+		MinAmpl:       params["minampl"].(float64),
+		Flat:          params["flat"].(float64),
+		Jagged:        params["jagged"].(float64),
+		Scale:         params["scale"].(float64),
+		Density:       params["density"].(float64),
+		DPR:           params["dpr"].(float64),
+		Extend:        params["extend"].(bimg.Extend),
+		Gravity:       params["gravity"].(bimg.Gravity),
+		Colorspace:    params["colorspace"].(bimg.Interpretation),
+		Background:    params["background"].([]uint8),
+		Operations:    params["operations"].([]PipelineOperation),
+		Widths:        params["widths"].([]int),
+		Types:         params["types"].([]string),
+		Output:        params["output"].(string),
+		Subsampling:   params["subsampling"].(string),
+		Progressive:   params["progressive"].(bool),
+		Lossless:      params["lossless"].(bool),
+		NearLossless:  params["nearlossless"].(bool),
+		Palette:       params["palette"].(bool),
+		PaletteColors: params["palettecolors"].(int),
+		Strip:         params["strip"].(bool),
+	}
+}
+
+func parseBool(val string) (bool, error) {
+	if val == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, errors.New("must be a boolean")
+	}
+	return value, nil
+}
+
+// parseInt parses integer params, rounding the decimal values, which are invalid
+func parseInt(param string) (int, error) {
+	if param == "" {
+		return 0, nil
+	}
+	val, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return 0, errors.New("must be an integer")
+	}
+	value := int(math.Floor(val + 0.5))
+	if float64(value) != val {
+		return value, errors.New("must be an integer")
+	}
+	return value, nil
+}
+
+// parseOptionalInt parses integer params whose zero value is meaningful, returning nil if not defined
+func parseOptionalInt(param string) (*int, error) {
+	if strings.TrimSpace(param) == "" {
+		return nil, nil
+	}
+	value, err := parseInt(param)
+	return &value, err
+}
+
+func parseFloat(param string) (float64, error) {
+	if param == "" {
+		return 0, nil
+	}
+	val, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return 0, errors.New("must be a number")
+	}
+	return val, nil
+}
+
+// dimension represents an absolute, in pixels, or relative, in percentage, image dimension
+type dimension struct {
+	Pixels  int
+	Percent float64
+}
+
+// parseDimension parses absolute dimensions, such as 300, or percentages
+// of the source image dimension, such as 50p
+func parseDimension(val string) (dimension, error) {
+	val = strings.TrimSpace(strings.ToLower(val))
+	if strings.HasSuffix(val, "p") {
+		percent, err := parseFloat(strings.TrimSuffix(val, "p"))
+		if err != nil || percent <= 0 || percent > 1000 {
+			return dimension{}, errors.New("must be a percentage between 0 and 1000, such as 50p")
+		}
+		return dimension{Percent: percent}, nil
+	}
+	pixels, err := parseInt(val)
+	if err != nil {
+		return dimension{Pixels: pixels}, errors.New("must be an integer or a percentage, such as 50p")
+	}
+	return dimension{Pixels: pixels}, nil
+}
+
+// qualityParam represents an encoding quality or the automatic perceptual quality
+type qualityParam struct {
+	Value int
+	Auto  bool
+}
+
+// parseQuality parses the encoding quality, such as 80, or auto
+func parseQuality(val string) (qualityParam, error) {
+	if strings.TrimSpace(strings.ToLower(val)) == "auto" {
+		return qualityParam{Auto: true}, nil
+	}
+	value, err := parseInt(val)
+	if err != nil {
+		return qualityParam{Value: value}, errors.New("must be an integer or auto")
+	}
+	return qualityParam{Value: value}, nil
+}
+
+// parseAspectRatio parses aspect ratios, such as 16:9 or 1.5, as width/height ratio
+func parseAspectRatio(val string) (float64, error) {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return 0, nil
+	}
+
+	ratio := 0.0
+	parts := strings.Split(val, ":")
+	if len(parts) == 1 {
+		ratio, _ = parseFloat(val)
+	} else if len(parts) == 2 {
+		width, err := parseFloat(strings.TrimSpace(parts[0]))
+		height, _err := parseFloat(strings.TrimSpace(parts[1]))
+		if err == nil && _err == nil && height != 0 {
+			ratio = width / height
+		}
+	}
+
+	if ratio <= 0 || math.IsInf(ratio, 0) || math.IsNaN(ratio) {
+		return 0, errors.New("must be an aspect ratio, such as 16:9 or 1.5")
+	}
+	return ratio, nil
+}
+
+func parseList(val string) []string {
+	list := []string{}
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(strings.ToLower(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func parseIntList(val string) ([]int, error) {
+	list := []int{}
+	for _, item := range parseList(val) {
+		value, err := parseInt(item)
+		if err != nil {
+			return []int{}, errors.New("must be a comma separated list of integers")
+		}
+		list = append(list, value)
+	}
+	return list, nil
 }
 
 func parseColorspace(val string) bimg.Interpretation {
@@ -133,16 +460,30 @@ func parseColorspace(val string) bimg.Interpretation {
 	return bimg.InterpretationSRGB
 }
 
-func parseColor(val string) []uint8 {
+// parseColor parses RGB colors, such as 255,200,150, clamping the channels to 255
+func parseColor(val string) ([]uint8, error) {
 	const max float64 = 255
 	buf := []uint8{}
-	if val != "" {
-		for _, num := range strings.Split(val, ",") {
-			n, _ := strconv.ParseUint(strings.Trim(num, " "), 10, 8)
-			buf = append(buf, uint8(math.Min(float64(n), max)))
+	if val == "" {
+		return buf, nil
+	}
+
+	var err error
+	for _, num := range strings.Split(val, ",") {
+		n, _err := strconv.ParseUint(strings.Trim(num, " "), 10, 8)
+		if _err != nil {
+			err = _err
 		}
+		buf = append(buf, uint8(math.Min(float64(n), max)))
+	}
+
+	if len(buf) != 3 {
+		return []uint8{}, errors.New("must be a RGB color, such as 255,200,150")
 	}
-	return buf
+	if err != nil {
+		return buf, errors.New("must be a RGB color, such as 255,200,150")
+	}
+	return buf, nil
 }
 
 func parseExtendMode(val string) bimg.Extend {
@@ -176,5 +517,22 @@ func parseGravity(val string) bimg.Gravity {
 	if val == "west" {
 		return bimg.GravityWest
 	}
+	if val == "smart" {
+		return bimg.GravitySmart
+	}
 	return bimg.GravityCentre
 }
+
+func parseJSONOperations(data string) ([]PipelineOperation, error) {
+	operations := []PipelineOperation{}
+	if data == "" {
+		return operations, nil
+	}
+
+	// Fallback to empty operations list in case of invalid JSON data
+	if err := json.Unmarshal([]byte(data), &operations); err != nil {
+		return []PipelineOperation{}, errors.New("must be a JSON array of operations: " + err.Error())
+	}
+
+	return operations, nil
+}
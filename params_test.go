@@ -17,14 +17,20 @@ func TestReadParams(t *testing.T) {
 	q.Add("opacity", "0.2")
 	q.Add("text", "hello")
 	q.Add("background", "255,10,20")
+	q.Add("embed", "true")
 
-	params := readParams(q)
+	params, errs := readParams(q)
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %#v", errs)
+	}
 
 	assert := params.Width == 100 &&
 		params.Height == 80 &&
 		params.NoReplicate == true &&
 		params.Opacity == 0.2 &&
 		params.Text == "hello" &&
+		params.Embed == true &&
+		params.Flop == false &&
 		params.Background[0] == 255 &&
 		params.Background[1] == 10 &&
 		params.Background[2] == 20
@@ -34,6 +40,103 @@ func TestReadParams(t *testing.T) {
 	}
 }
 
+func TestReadParamsErrors(t *testing.T) {
+	q := url.Values{}
+	q.Set("width", "abc")
+	q.Set("height", "-100")
+	q.Set("quality", "101")
+	q.Set("top", "10.5")
+	q.Set("type", "bmp")
+	q.Set("background", "255,10")
+	q.Set("left", "10")
+
+	params, errs := readParams(q)
+
+	expected := []string{"background", "height", "quality", "top", "type", "width"}
+	if len(errs) != len(expected) {
+		t.Fatalf("Invalid errors: %#v", errs)
+	}
+	for i, err := range errs {
+		if err.Param != expected[i] || err.Message == "" {
+			t.Errorf("Invalid error: %#v", err)
+		}
+	}
+
+	// Out of range values are preserved, while the invalid ones are ignored
+	if params.Width != 0 || params.Height != -100 || params.Quality != 101 || params.Left != 10 || len(params.Background) != 0 {
+		t.Errorf("Invalid params: %#v", params)
+	}
+}
+
+func TestReadParamsThreshold(t *testing.T) {
+	if params, _ := readParams(url.Values{}); params.Threshold != nil {
+		t.Errorf("Threshold must not be defined: %d", *params.Threshold)
+	}
+
+	params, _ := readParams(url.Values{"threshold": {"0"}})
+	if params.Threshold == nil || *params.Threshold != 0 {
+		t.Errorf("Invalid threshold: %#v", params.Threshold)
+	}
+}
+
+func TestReadMapParams(t *testing.T) {
+	params := map[string]interface{}{
+		"width":  float64(300),
+		"height": "200",
+		"force":  true,
+		"type":   "png",
+	}
+
+	opts, errs := readMapParams(params)
+	if len(errs) > 0 {
+		t.Fatalf("Cannot read params: %#v", errs)
+	}
+
+	if opts.Width != 300 || opts.Height != 200 || opts.Force != true || opts.Type != "png" {
+		t.Errorf("Invalid params: %#v", opts)
+	}
+
+	_, errs = readMapParams(map[string]interface{}{"width": []interface{}{1, 2}})
+	if len(errs) != 1 || errs[0].Param != "params" {
+		t.Errorf("Expected error for invalid param value: %#v", errs)
+	}
+
+	_, errs = readMapParams(map[string]interface{}{"width": "abc"})
+	if len(errs) != 1 || errs[0].Param != "width" {
+		t.Errorf("Expected error for invalid width: %#v", errs)
+	}
+}
+
+func TestParseJSONOperations(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected int
+		invalid  bool
+	}{
+		{`[{"operation": "crop", "params": {"width": 300}}, {"operation": "convert", "params": {"type": "png"}}]`, 2, false},
+		{`[{"operation": "flip"}]`, 1, false},
+		{`[]`, 0, false},
+		{`{"operation": "crop"}`, 0, true},
+		{`invalid`, 0, true},
+		{"", 0, false},
+	}
+
+	for _, test := range cases {
+		operations, err := parseJSONOperations(test.value)
+		if len(operations) != test.expected {
+			t.Errorf("Invalid operations length: %d != %d", len(operations), test.expected)
+		}
+		if (err != nil) != test.invalid {
+			t.Errorf("Unexpected error for %s: %v", test.value, err)
+		}
+	}
+
+	operations, _ := parseJSONOperations(`[{"operation": "crop", "params": {"width": 300}}]`)
+	if operations[0].Name != "crop" || operations[0].Params["width"] != float64(300) {
+		t.Errorf("Invalid operation: %#v", operations[0])
+	}
+}
+
 func TestParseParam(t *testing.T) {
 	intCases := []struct {
 		value    string
@@ -41,13 +144,13 @@ func TestParseParam(t *testing.T) {
 	}{
 		{"1", 1},
 		{"0100", 100},
-		{"-100", 100},
+		{"-100", -100},
 		{"99.02", 99},
 		{"99.9", 100},
 	}
 
 	for _, test := range intCases {
-		val := parseParam(test.value, "int")
+		val, _ := parseParam(test.value, "int")
 		if val != test.expected {
 			t.Errorf("Invalid param: %s != %d", test.value, test.expected)
 		}
@@ -59,12 +162,12 @@ func TestParseParam(t *testing.T) {
 	}{
 		{"1.1", 1.1},
 		{"01.1", 1.1},
-		{"-1.10", 1.10},
+		{"-1.10", -1.10},
 		{"99.999999", 99.999999},
 	}
 
 	for _, test := range floatCases {
-		val := parseParam(test.value, "float")
+		val, _ := parseParam(test.value, "float")
 		if val != test.expected {
 			t.Errorf("Invalid param: %#v != %#v", val, test.expected)
 		}
@@ -86,7 +189,7 @@ func TestParseParam(t *testing.T) {
 	}
 
 	for _, test := range boolCases {
-		val := parseParam(test.value, "bool")
+		val, _ := parseParam(test.value, "bool")
 		if val != test.expected {
 			t.Errorf("Invalid param: %#v != %#v", val, test.expected)
 		}
@@ -106,7 +209,7 @@ func TestParseColor(t *testing.T) {
 	}
 
 	for _, color := range cases {
-		c := parseColor(color.value)
+		c, _ := parseColor(color.value)
 		l := len(color.expected)
 
 		if len(c) != l {
@@ -148,3 +251,105 @@ func TestParseExtend(t *testing.T) {
 		}
 	}
 }
+
+func TestParseGravity(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected bimg.Gravity
+	}{
+		{"north", bimg.GravityNorth},
+		{"south", bimg.GravitySouth},
+		{"east", bimg.GravityEast},
+		{"west", bimg.GravityWest},
+		{"centre", bimg.GravityCentre},
+		{"smart", bimg.GravitySmart},
+		{" SMART ", bimg.GravitySmart},
+		{"invalid", bimg.GravityCentre},
+		{"", bimg.GravityCentre},
+	}
+
+	for _, gravity := range cases {
+		g := parseGravity(gravity.value)
+		if g != gravity.expected {
+			t.Errorf("Invalid gravity value : %d != %d", g, gravity.expected)
+		}
+	}
+}
+
+func TestParseIntList(t *testing.T) {
+	list, err := parseIntList("320, 640,,1024")
+	if err != nil || len(list) != 3 || list[0] != 320 || list[1] != 640 || list[2] != 1024 {
+		t.Errorf("Invalid int list: %#v", list)
+	}
+
+	if list, _ := parseIntList(""); len(list) != 0 {
+		t.Errorf("Invalid empty int list: %#v", list)
+	}
+
+	if list, err := parseIntList("320,abc"); err == nil || len(list) != 0 {
+		t.Errorf("Invalid empty int list: %#v", list)
+	}
+}
+
+func TestParseList(t *testing.T) {
+	list := parseList("JPEG, webp")
+	if len(list) != 2 || list[0] != "jpeg" || list[1] != "webp" {
+		t.Errorf("Invalid list: %#v", list)
+	}
+}
+
+func TestParseDimension(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected dimension
+	}{
+		{"300", dimension{Pixels: 300}},
+		{"50p", dimension{Percent: 50}},
+		{"12.5P", dimension{Percent: 12.5}},
+		{"", dimension{}},
+	}
+
+	for _, test := range cases {
+		if dim, _ := parseDimension(test.value); dim != test.expected {
+			t.Errorf("Invalid dimension for %s: %#v", test.value, dim)
+		}
+	}
+}
+
+func TestParseAspectRatio(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected float64
+	}{
+		{"16:9", 16.0 / 9},
+		{"1:1", 1},
+		{"1.5", 1.5},
+		{"16:0", 0},
+		{"1:2:3", 0},
+		{"", 0},
+	}
+
+	for _, test := range cases {
+		if aspect, _ := parseAspectRatio(test.value); aspect != test.expected {
+			t.Errorf("Invalid aspect ratio for %s: %f", test.value, aspect)
+		}
+	}
+}
+
+func TestParseQuality(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected qualityParam
+	}{
+		{"80", qualityParam{Value: 80}},
+		{"auto", qualityParam{Auto: true}},
+		{"AUTO", qualityParam{Auto: true}},
+		{"", qualityParam{}},
+	}
+
+	for _, test := range cases {
+		if quality, _ := parseQuality(test.value); quality != test.expected {
+			t.Errorf("Invalid quality for %s: %#v", test.value, quality)
+		}
+	}
+}
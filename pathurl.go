@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// pathParamAliases defines the short names of the params allowed in path-style URLs
+var pathParamAliases = map[string]string{
+	"w": "width",
+	"h": "height",
+	"q": "quality",
+}
+
+// pathNoOptions defines the options segment of path-style URLs without params, such as /info/-/image.jpg
+const pathNoOptions = "-"
+
+var ErrInvalidPathURL = NewError("Invalid path-style URL: expected /{operation}/{options}/{image path}", BadRequest)
+
+// pathController serves the path-style URLs, such as /resize/w:300,h:200/path/to/image.jpg, which define
+// the operation params as path segment and read the image from the rest of the path, resolved against
+// the configured origin, if any, or the mounted directory.
+func pathController(o ServerOptions, name string, operation Operation) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			ErrorReply(r, w, ErrMethodNotAllowed, o)
+			return
+		}
+
+		route := join(o, "/"+name)
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, route+"/"), "/", 2)
+		if len(parts) != 2 || parts[0] == "" || strings.Trim(parts[1], "/") == "" {
+			ErrorReply(r, w, ErrInvalidPathURL, o)
+			return
+		}
+
+		params, err := parsePathParams(parts[0])
+		if err != nil {
+			ErrorReply(r, w, err.(Error), o)
+			return
+		}
+
+		buf, err := readPathImage(r, path.Clean("/"+parts[1]), o)
+		if err != nil {
+			if xerr, ok := err.(Error); ok {
+				ErrorReply(r, w, xerr, o)
+				return
+			}
+			ErrorReply(r, w, NewError(err.Error(), BadRequest), o)
+			return
+		}
+		if len(buf) == 0 {
+			ErrorReply(r, w, ErrEmptyBody, o)
+			return
+		}
+
+		// The request is processed as the equivalent query-string one, such as /resize?width=300&height=200.
+		// Query params are also allowed, except the image source ones.
+		query := r.URL.Query()
+		for _, param := range []string{"file", "url", "bucket", "object"} {
+			query.Del(param)
+		}
+		for param, value := range params {
+			query[param] = value
+		}
+
+		req := *r
+		req.URL = &url.URL{Path: route, RawQuery: query.Encode()}
+		imageHandler(w, &req, buf, operation, o)
+	}
+}
+
+// supportsPathStyleURL reports whether the operation can be requested via path-style URLs.
+// Pipelines are excluded, since their operations are defined as JSON.
+func supportsPathStyleURL(name string) bool {
+	return name != "pipeline"
+}
+
+// parsePathParams parses the options segment of path-style URLs, such as w:300,h:200,type:webp,
+// into the equivalent query params. Values containing commas, such as colors, are also supported,
+// such as background:255,200,150.
+func parsePathParams(options string) (url.Values, error) {
+	params := url.Values{}
+	if options == pathNoOptions {
+		return params, nil
+	}
+
+	name := ""
+	for _, token := range strings.Split(options, ",") {
+		pair := strings.SplitN(token, ":", 2)
+		if len(pair) == 1 {
+			if name == "" {
+				return nil, NewError("Invalid path param: "+token, BadRequest)
+			}
+			params.Set(name, params.Get(name)+","+token)
+			continue
+		}
+
+		name = pair[0]
+		if alias, ok := pathParamAliases[name]; ok {
+			name = alias
+		}
+		if _, ok := allowedParams[name]; !ok {
+			return nil, NewError("Unknown path param: "+pair[0], BadRequest)
+		}
+		params.Set(name, pair[1])
+	}
+
+	return params, nil
+}
+
+// readPathImage reads the image of path-style URLs from the configured origin, if any,
+// or the mounted directory, applying the image sources rules
+func readPathImage(r *http.Request, file string, o ServerOptions) ([]byte, error) {
+	if o.PathOrigin != "" {
+		// The origin is trusted, so it's not restricted by the allowed origins
+		config := newSourceConfig(ImageSourceTypeHttp, o)
+		config.AllowedOrigings = nil
+		source := NewHttpImageSource(config)
+
+		// The path is escaped, so the image path may contain reserved characters, such as ? or #
+		origin, err := url.Parse(o.PathOrigin)
+		if err != nil {
+			return nil, NewError("Invalid path origin URL: "+err.Error(), InternalError)
+		}
+		origin.Path = strings.TrimSuffix(origin.Path, "/") + file
+		return source.GetImage(sourceRequest(r, "url", origin.String()))
+	}
+
+	if !isSourceEnabled(ImageSourceTypeFileSystem, o) {
+		return nil, NewError("Path-style URLs require the -mount or -path-origin flags", NotAllowed)
+	}
+	return readImageFromSource(r, ImageSourceTypeFileSystem, "file", file)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParsePathParams(t *testing.T) {
+	cases := []struct {
+		options  string
+		expected map[string]string
+	}{
+		{"w:300,h:200", map[string]string{"width": "300", "height": "200"}},
+		{"width:300,type:webp,q:80", map[string]string{"width": "300", "type": "webp", "quality": "80"}},
+		{"w:300,background:255,200,150,flip:true", map[string]string{"width": "300", "background": "255,200,150", "flip": "true"}},
+		{"aspect:16:9,width:300", map[string]string{"aspect": "16:9", "width": "300"}},
+		{"-", map[string]string{}},
+	}
+
+	for _, test := range cases {
+		params, err := parsePathParams(test.options)
+		if err != nil {
+			t.Fatalf("Cannot parse %s: %s", test.options, err)
+		}
+		if len(params) != len(test.expected) {
+			t.Errorf("Invalid params for %s: %#v", test.options, params)
+		}
+		for name, value := range test.expected {
+			if params.Get(name) != value {
+				t.Errorf("Invalid %s param for %s: %s != %s", name, test.options, params.Get(name), value)
+			}
+		}
+	}
+}
+
+func TestParsePathParamsInvalid(t *testing.T) {
+	for _, options := range []string{"foo:bar", "300", "file:large.jpg", "url:http"} {
+		if _, err := parsePathParams(options); err == nil {
+			t.Errorf("Invalid params must fail: %s", options)
+		}
+	}
+}
+
+func TestReadPathImageEscaped(t *testing.T) {
+	requested := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requested = req.RequestURI
+		w.WriteHeader(404)
+	}))
+	defer ts.Close()
+
+	r, _ := http.NewRequest("GET", "http://foo/resize/w:300/a%3Fb%23c.jpg", nil)
+	readPathImage(r, "/a?b#c.jpg", ServerOptions{PathOrigin: ts.URL + "/images/"})
+
+	if requested != "/images/a%3Fb%23c.jpg" {
+		t.Errorf("Invalid origin request: %s", requested)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+const (
+	// qualityHeader exposes the quality chosen by maxbytes or quality=auto params
+	qualityHeader = "X-Image-Quality"
+	// minSearchQuality is the lowest quality tried to meet a byte budget or the perceptual target
+	minSearchQuality = 10
+	// autoQualityTarget is the minimum structural similarity to the original image of the auto quality
+	autoQualityTarget = 0.98
+	// ssimSampleSize is the image size, in its longest side, used to measure the structural similarity
+	ssimSampleSize = 512
+	// maxDownscaleSteps limits the downscaling attempts to meet a byte budget
+	maxDownscaleSteps = 8
+)
+
+// isLossyImageType reports whether the image type encoder supports the quality param
+func isLossyImageType(kind bimg.ImageType) bool {
+	return kind == bimg.JPEG || kind == bimg.WEBP || kind == bimg.HEIF || kind == bimg.AVIF || kind == JXL
+}
+
+// searchQualityRange returns the range of qualities to search, up to the given one
+func searchQualityRange(maxQuality int) (int, int) {
+	if maxQuality < minSearchQuality {
+		return maxQuality, maxQuality
+	}
+	return minSearchQuality, maxQuality
+}
+
+// runOperation performs the image operation, searching the output quality which meets
+// the maxbytes budget or the auto quality target, if defined, and applying the encoder
+// params not supported by bimg. Only the image transformation operations are encoded here,
+// while the rest of operations, such as srcset or pipeline, encode their own outputs.
+func runOperation(name string, operation Operation, buf []byte, o ImageOptions) (Image, error) {
+	kind := outputImageType(buf, o)
+	if _, ok := OperationsMap[name]; !ok || !needsOutputEncoding(o, kind) {
+		return operation.Run(buf, o)
+	}
+
+	// The operation output is losslessly encoded, and then encoded only once
+	// with the chosen quality and the encoder params, preventing generation loss
+	image, err := operation.Run(buf, losslessOptions(o))
+	if err != nil || !strings.HasPrefix(image.Mime, "image/") {
+		return image, err
+	}
+	return EncodeOutput(image, kind, o)
+}
+
+// needsOutputEncoding reports whether the output image must be encoded from a lossless intermediate
+// image, in order to search its quality or to apply the encoder params not supported by bimg
+func needsOutputEncoding(o ImageOptions, kind bimg.ImageType) bool {
+	return o.MaxBytes > 0 || o.AutoQuality || needsVipsEncoder(o, kind)
+}
+
+// EncodeOutput encodes the losslessly encoded image with the given type and encoder params,
+// searching the quality which meets the maxbytes budget or the auto quality target, if defined
+func EncodeOutput(img Image, kind bimg.ImageType, o ImageOptions) (Image, error) {
+	if o.MaxBytes > 0 || o.AutoQuality {
+		return OptimizeQuality(img, kind, o)
+	}
+
+	encoded, err := EncodeImage(img.Body, kind, o.Quality, 0, o)
+	if err != nil {
+		return Image{}, err
+	}
+	encoded.Headers = img.Headers
+	return encoded, nil
+}
+
+// losslessOptions returns the options to encode the operation output as lossless PNG
+// intermediate image, without the encoder params of the final output type
+func losslessOptions(o ImageOptions) ImageOptions {
+	o.Type = "png"
+	o.Quality = 0
+	o.Progressive, o.Lossless, o.NearLossless, o.Palette = false, false, false, false
+	o.Effort, o.PaletteColors, o.Subsampling = 0, 0, ""
+	return o
+}
+
+// OptimizeQuality encodes the image with the given type, using the lowest quality meeting the auto
+// quality target, if required, and the highest quality fitting the maxbytes budget, if defined, up
+// to the given quality, downscaling the image if it doesn't fit even with the lowest quality.
+// The image is expected to be losslessly encoded, and the chosen quality is exposed via the
+// X-Image-Quality response header.
+func OptimizeQuality(img Image, kind bimg.ImageType, o ImageOptions) (Image, error) {
+	lossy := isLossyImageType(kind)
+
+	maxQuality := o.Quality
+	if maxQuality == 0 {
+		maxQuality = 100
+	}
+
+	var result Image
+	var err error
+	quality := maxQuality
+
+	if lossy && o.AutoQuality {
+		result, quality, err = autoQuality(img, kind, maxQuality, o)
+	} else {
+		// Lossless images can only be encoded with the given compression and encoder params
+		result, err = EncodeImage(img.Body, kind, maxQuality, 0, o)
+	}
+	if err != nil {
+		return Image{}, err
+	}
+
+	if lossy && o.MaxBytes > 0 && len(result.Body) > o.MaxBytes {
+		if result, quality, err = budgetQuality(img, kind, quality, o); err != nil {
+			return Image{}, err
+		}
+	}
+
+	if o.MaxBytes > 0 && len(result.Body) > o.MaxBytes {
+		if result, err = downscaleToBudget(img, len(result.Body), kind, quality, o); err != nil {
+			return Image{}, err
+		}
+	}
+
+	result.Headers = img.Headers
+	if lossy {
+		if result.Headers == nil {
+			result.Headers = map[string]string{}
+		}
+		result.Headers[qualityHeader] = strconv.Itoa(quality)
+	}
+
+	return result, nil
+}
+
+// autoQuality searches the lowest quality whose decoded image is perceptually similar
+// to the original losslessly encoded one, based on the structural similarity index
+func autoQuality(img Image, kind bimg.ImageType, maxQuality int, o ImageOptions) (Image, int, error) {
+	original, err := sampleImage(img.Body, ssimSampleSize, bimg.Options{NoAutoRotate: true})
+	if err != nil {
+		return Image{}, 0, err
+	}
+
+	var best Image
+	bestQuality := 0
+	low, high := searchQualityRange(maxQuality)
+
+	for low <= high {
+		quality := (low + high) / 2
+		encoded, err := EncodeImage(img.Body, kind, quality, 0, o)
+		if err != nil {
+			return Image{}, 0, err
+		}
+
+		sample, err := sampleImage(encoded.Body, ssimSampleSize, bimg.Options{NoAutoRotate: true})
+		if err != nil {
+			return Image{}, 0, err
+		}
+
+		if SSIM(original, sample) >= autoQualityTarget {
+			best, bestQuality = encoded, quality
+			high = quality - 1
+		} else {
+			low = quality + 1
+		}
+	}
+
+	if bestQuality == 0 {
+		encoded, err := EncodeImage(img.Body, kind, maxQuality, 0, o)
+		return encoded, maxQuality, err
+	}
+	return best, bestQuality, nil
+}
+
+// budgetQuality searches the highest quality, up to the given one, fitting the maxbytes budget.
+// The lowest quality is returned if none fits.
+func budgetQuality(img Image, kind bimg.ImageType, maxQuality int, o ImageOptions) (Image, int, error) {
+	var best, lowest Image
+	bestQuality := 0
+	low, high := searchQualityRange(maxQuality)
+	minQuality := low
+
+	for low <= high {
+		quality := (low + high) / 2
+		encoded, err := EncodeImage(img.Body, kind, quality, 0, o)
+		if err != nil {
+			return Image{}, 0, err
+		}
+
+		if len(encoded.Body) <= o.MaxBytes {
+			best, bestQuality = encoded, quality
+			low = quality + 1
+		} else {
+			lowest = encoded
+			high = quality - 1
+		}
+	}
+
+	// The last quality tried is the lowest one, if none fits
+	if bestQuality == 0 {
+		return lowest, minQuality, nil
+	}
+	return best, bestQuality, nil
+}
+
+// downscaleToBudget reduces the image dimensions, keeping its aspect ratio, until it fits the maxbytes
+// budget, starting from the given encoded size at the original dimensions
+func downscaleToBudget(img Image, size int, kind bimg.ImageType, quality int, o ImageOptions) (Image, error) {
+	width, _, err := readImageSize(img.Body)
+	if err != nil {
+		return Image{}, err
+	}
+
+	var result Image
+	for i := 0; i < maxDownscaleSteps && size > o.MaxBytes; i++ {
+		// The encoded size is roughly proportional to the number of pixels
+		ratio := math.Sqrt(float64(o.MaxBytes)/float64(size)) * 0.95
+		width = int(math.Max(1, math.Floor(float64(width)*ratio)))
+
+		if result, err = EncodeImage(img.Body, kind, quality, width, o); err != nil {
+			return Image{}, err
+		}
+		size = len(result.Body)
+		if width == 1 {
+			break
+		}
+	}
+
+	if size > o.MaxBytes {
+		return Image{}, NewError(fmt.Sprintf("Cannot encode the image within %d bytes", o.MaxBytes), Unprocessable)
+	}
+	return result, nil
+}
+
+// SSIM calculates the mean structural similarity index of the luminance of two images of the
+// same size, using 8x8 windows. It returns 1 for identical images.
+func SSIM(a, b image.Image) float64 {
+	const window = 8
+	const c1 = (0.01 * 255) * (0.01 * 255)
+	const c2 = (0.03 * 255) * (0.03 * 255)
+
+	bounds := a.Bounds()
+	if bounds.Dx() != b.Bounds().Dx() || bounds.Dy() != b.Bounds().Dy() {
+		return 0
+	}
+
+	luma := func(img image.Image, x, y int) float64 {
+		red, green, blue, _ := img.At(x, y).RGBA()
+		return (0.299*float64(red) + 0.587*float64(green) + 0.114*float64(blue)) / 257
+	}
+
+	total, windows := 0.0, 0
+	for y := bounds.Min.Y; y+window <= bounds.Max.Y; y += window / 2 {
+		for x := bounds.Min.X; x+window <= bounds.Max.X; x += window / 2 {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for wy := y; wy < y+window; wy++ {
+				for wx := x; wx < x+window; wx++ {
+					la := luma(a, wx, wy)
+					lb := luma(b, b.Bounds().Min.X+wx-bounds.Min.X, b.Bounds().Min.Y+wy-bounds.Min.Y)
+					sumA += la
+					sumB += lb
+					sumAA += la * la
+					sumBB += lb * lb
+					sumAB += la * lb
+				}
+			}
+
+			n := float64(window * window)
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			covar := sumAB/n - meanA*meanB
+
+			total += ((2*meanA*meanB + c1) * (2*covar + c2)) / ((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+
+	if windows == 0 {
+		return 1
+	}
+	return total / float64(windows)
+}
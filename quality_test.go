@@ -0,0 +1,117 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"strconv"
+	"testing"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+func TestSSIM(t *testing.T) {
+	original := wavesImage(64, 64)
+
+	if ssim := SSIM(original, original); ssim < 0.9999 {
+		t.Errorf("Identical images must have SSIM 1: %f", ssim)
+	}
+
+	noisy := image.NewRGBA(original.Bounds())
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			r, g, b, _ := original.At(x, y).RGBA()
+			noise := uint8((x*7 + y*13) % 64)
+			noisy.Set(x, y, color.RGBA{uint8(r>>8) ^ noise, uint8(g>>8) ^ noise, uint8(b>>8) ^ noise, 255})
+		}
+	}
+
+	if ssim := SSIM(original, noisy); ssim >= autoQualityTarget {
+		t.Errorf("Noisy images must have lower SSIM: %f", ssim)
+	}
+
+	if ssim := SSIM(original, wavesImage(32, 32)); ssim != 0 {
+		t.Errorf("Images with different sizes must have SSIM 0: %f", ssim)
+	}
+}
+
+func TestSearchQualityRange(t *testing.T) {
+	if low, high := searchQualityRange(80); low != minSearchQuality || high != 80 {
+		t.Errorf("Invalid quality range: %d-%d", low, high)
+	}
+	if low, high := searchQualityRange(5); low != 5 || high != 5 {
+		t.Errorf("Invalid quality range: %d-%d", low, high)
+	}
+}
+
+func TestImageMaxBytes(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+	opts := ImageOptions{Width: 800, MaxBytes: 40000}
+
+	img, err := runOperation("resize", Resize, buf, opts)
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if len(img.Body) > opts.MaxBytes {
+		t.Errorf("Image exceeds the byte budget: %d", len(img.Body))
+	}
+
+	quality, err := strconv.Atoi(img.Headers[qualityHeader])
+	if err != nil || quality < minSearchQuality || quality > 100 {
+		t.Errorf("Invalid quality header: %s", img.Headers[qualityHeader])
+	}
+
+	tiny := ImageOptions{Width: 800, MaxBytes: 2000}
+	img, err = runOperation("resize", Resize, buf, tiny)
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if len(img.Body) > tiny.MaxBytes {
+		t.Errorf("Image exceeds the byte budget: %d", len(img.Body))
+	}
+	if width, _, _ := readImageSize(img.Body); width >= 800 {
+		t.Errorf("Image must be downscaled to fit the byte budget: %d", width)
+	}
+}
+
+func TestImageMaxBytesQuality(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	// The given quality is kept if the image already fits the byte budget
+	img, err := runOperation("resize", Resize, buf, ImageOptions{Width: 300, Quality: 60, MaxBytes: 1000000})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Headers[qualityHeader] != "60" {
+		t.Errorf("Invalid quality header: %s", img.Headers[qualityHeader])
+	}
+	if bimg.DetermineImageType(img.Body) != bimg.JPEG {
+		t.Errorf("Invalid output image type: %s", bimg.DetermineImageTypeName(img.Body))
+	}
+}
+
+func TestLosslessOptions(t *testing.T) {
+	opts := losslessOptions(ImageOptions{
+		Width: 300, Type: "webp", Quality: 80, Effort: 4, NearLossless: true, Subsampling: "444", MaxBytes: 1000,
+	})
+	if opts.Type != "png" || opts.Quality != 0 || opts.Effort != 0 || opts.NearLossless || opts.Subsampling != "" {
+		t.Errorf("Invalid lossless options: %#v", opts)
+	}
+	if opts.Width != 300 || opts.MaxBytes != 1000 {
+		t.Errorf("Lossless options must preserve the operation params: %#v", opts)
+	}
+}
+
+func TestImageAutoQuality(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	img, err := runOperation("resize", Resize, buf, ImageOptions{Width: 400, AutoQuality: true})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+
+	quality, err := strconv.Atoi(img.Headers[qualityHeader])
+	if err != nil || quality < minSearchQuality || quality > 100 {
+		t.Errorf("Invalid quality header: %s", img.Headers[qualityHeader])
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// rotateOptions defines the free-angle rotation performed via libvips
+type rotateOptions struct {
+	Angle      float64
+	Background []uint8
+	Alpha      bool
+	AutoRotate bool
+	// Width and Height define the centered area to crop after the rotation, if any
+	Width  int
+	Height int
+}
+
+// isRightAngle reports whether the angle is a multiple of 90 degrees, supported by bimg rotation
+func isRightAngle(angle float64) bool {
+	return math.Mod(angle, 90) == 0
+}
+
+// normalizeAngle converts the angle, in degrees, to the equivalent clockwise angle between 0 and 360,
+// such as -90 to 270
+func normalizeAngle(angle float64) float64 {
+	angle = math.Mod(angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+	return angle
+}
+
+// supportsAlpha reports whether the image type can encode transparency
+func supportsAlpha(kind bimg.ImageType) bool {
+	return kind == bimg.PNG || kind == bimg.WEBP || kind == bimg.TIFF || kind == bimg.GIF || kind == bimg.HEIF || kind == bimg.AVIF
+}
+
+// inscribedSize calculates the largest axis-aligned rectangle fitting in the image
+// of the given size once rotated by the given angle, in degrees.
+func inscribedSize(width, height int, angle float64) (int, int) {
+	if width <= 0 || height <= 0 {
+		return 0, 0
+	}
+
+	w, h := float64(width), float64(height)
+	long, short := math.Max(w, h), math.Min(w, h)
+	sin := math.Abs(math.Sin(angle * math.Pi / 180))
+	cos := math.Abs(math.Cos(angle * math.Pi / 180))
+
+	var cropWidth, cropHeight float64
+	if short <= 2*sin*cos*long || math.Abs(sin-cos) < 1e-10 {
+		// Half constrained case: two crop corners touch the longest side
+		x := 0.5 * short
+		if w >= h {
+			cropWidth, cropHeight = x/sin, x/cos
+		} else {
+			cropWidth, cropHeight = x/cos, x/sin
+		}
+	} else {
+		// Fully constrained case: the crop touches the four sides
+		cos2 := cos*cos - sin*sin
+		cropWidth, cropHeight = (w*cos-h*sin)/cos2, (h*cos-w*sin)/cos2
+	}
+
+	// Tolerate the floating point error of the exact sizes, such as with right angles
+	return int(math.Floor(cropWidth + 1e-6)), int(math.Floor(cropHeight + 1e-6))
+}
+
+// rotatedSize calculates the bounding box of the image of the given size
+// once rotated by the given angle, in degrees.
+func rotatedSize(width, height int, angle float64) (int, int) {
+	w, h := float64(width), float64(height)
+	sin := math.Abs(math.Sin(angle * math.Pi / 180))
+	cos := math.Abs(math.Cos(angle * math.Pi / 180))
+
+	// Tolerate the floating point error of the exact sizes, such as with right angles
+	return clampSize(w*cos + h*sin - 1e-6), clampSize(w*sin + h*cos - 1e-6)
+}
+
+// RotateImage rotates the image by any angle, filling the exposed corners with the background color,
+// or with transparency if the output image type supports it and no background is defined.
+// If inscribe is defined, the result is cropped to the largest rectangle without exposed corners.
+// The rotated image is losslessly encoded as PNG.
+func RotateImage(buf []byte, o ImageOptions) ([]byte, error) {
+	kind := ImageType(o.Type)
+	if o.Type == "" {
+		kind = bimg.DetermineImageType(buf)
+	}
+
+	opts := rotateOptions{
+		Angle:      normalizeAngle(o.Rotate),
+		Background: o.Background,
+		Alpha:      len(o.Background) == 0 && supportsAlpha(kind),
+		AutoRotate: !o.NoRotation,
+	}
+
+	if o.Inscribe {
+		meta, err := bimg.Metadata(buf)
+		if err != nil {
+			return nil, err
+		}
+		width, height := orientedSize(meta, bimg.Options{NoAutoRotate: o.NoRotation})
+		opts.Width, opts.Height = inscribedSize(width, height, opts.Angle)
+	}
+
+	return vipsRotate(buf, opts)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+func TestIsRightAngle(t *testing.T) {
+	cases := []struct {
+		angle    float64
+		expected bool
+	}{
+		{0, true},
+		{90, true},
+		{270, true},
+		{450, true},
+		{45, false},
+		{1.5, false},
+		{90.5, false},
+		{-90, true},
+		{-2.5, false},
+	}
+
+	for _, test := range cases {
+		if isRightAngle(test.angle) != test.expected {
+			t.Errorf("Invalid right angle for %g", test.angle)
+		}
+	}
+}
+
+func TestNormalizeAngle(t *testing.T) {
+	cases := []struct {
+		angle    float64
+		expected float64
+	}{
+		{0, 0},
+		{90, 90},
+		{-90, 270},
+		{-2.5, 357.5},
+		{450, 90},
+		{-360, 0},
+	}
+
+	for _, test := range cases {
+		if angle := normalizeAngle(test.angle); angle != test.expected {
+			t.Errorf("Invalid normalized angle for %g: %g", test.angle, angle)
+		}
+	}
+}
+
+func TestInscribedSize(t *testing.T) {
+	cases := []struct {
+		width, height  int
+		angle          float64
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{1000, 1000, 45, 707, 707},
+		{1000, 500, 10, 955, 339},
+		{500, 1000, 10, 339, 955},
+		{1000, 100, 45, 70, 70},
+		{1000, 100, 30, 100, 57},
+		{1000, 500, 180, 1000, 500},
+		{0, 500, 10, 0, 0},
+	}
+
+	for _, test := range cases {
+		width, height := inscribedSize(test.width, test.height, test.angle)
+		if width != test.expectedWidth || height != test.expectedHeight {
+			t.Errorf("Invalid inscribed size for %dx%d rotated %g: %dx%d", test.width, test.height, test.angle, width, height)
+		}
+	}
+}
+
+func TestRotatedSize(t *testing.T) {
+	cases := []struct {
+		width, height  int
+		angle          float64
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{1000, 1000, 45, 1415, 1415},
+		{1000, 500, 30, 1117, 934},
+		{1000, 500, -30, 1117, 934},
+		{1000, 500, 90, 500, 1000},
+		{1000, 500, 180, 1000, 500},
+	}
+
+	for _, test := range cases {
+		width, height := rotatedSize(test.width, test.height, test.angle)
+		if width != test.expectedWidth || height != test.expectedHeight {
+			t.Errorf("Invalid rotated size for %dx%d rotated %g: %dx%d", test.width, test.height, test.angle, width, height)
+		}
+	}
+}
+
+func TestImageRotateFreeAngle(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	img, err := Rotate(buf, ImageOptions{Rotate: 30, Type: "png"})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "image/png" {
+		t.Error("Invalid image MIME type")
+	}
+
+	meta, err := bimg.Metadata(img.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.Alpha {
+		t.Error("Rotated PNG image must have transparent corners")
+	}
+
+	size, _ := bimg.Size(buf)
+	inscribed, err := Rotate(buf, ImageOptions{Rotate: 30, Inscribe: true})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if inscribed.Mime != "image/jpeg" {
+		t.Error("Invalid image MIME type")
+	}
+
+	width, height := inscribedSize(size.Width, size.Height, 30)
+	if assertSize(inscribed.Body, width, height) != nil {
+		t.Errorf("Invalid image size, expected: %dx%d", width, height)
+	}
+}
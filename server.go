@@ -10,28 +10,40 @@ import (
 )
 
 type ServerOptions struct {
-	Port              int
-	Burst             int
-	Concurrency       int
-	HttpCacheTtl      int
-	HttpReadTimeout   int
-	HttpWriteTimeout  int
-	CORS              bool
-	Gzip              bool
-	AuthForwarding    bool
-	EnableURLSource   bool
-	EnablePlaceholder bool
-	Address           string
-	PathPrefix        string
-	ApiKey            string
-	Mount             string
-	CertFile          string
-	KeyFile           string
-	Authorization     string
-	Placeholder       string
-	PlaceholderImage  []byte
-	AlloweOrigins     []*url.URL
-	MaxAllowedSize    int
+	Port               int
+	Burst              int
+	Concurrency        int
+	HttpCacheTtl       int
+	HttpReadTimeout    int
+	HttpWriteTimeout   int
+	CORS               bool
+	Gzip               bool
+	AuthForwarding     bool
+	EnableURLSource    bool
+	EnableS3Source     bool
+	EnableGCSSource    bool
+	EnablePlaceholder  bool
+	RedactGPSMetadata  bool
+	StrictParams       bool
+	Address            string
+	PathPrefix         string
+	ApiKey             string
+	Mount              string
+	PathOrigin         string
+	CertFile           string
+	KeyFile            string
+	Authorization      string
+	Placeholder        string
+	PlaceholderImage   []byte
+	AlloweOrigins      []*url.URL
+	Sources            []ImageSourceType
+	MaxAllowedSize     int
+	MaxDPR             float64
+	MaxInputPixels     int
+	MaxInputDimension  int
+	MaxOutputDimension int
+	S3                 S3Config
+	GCS                GCSConfig
 }
 
 func Server(o ServerOptions) error {
@@ -60,6 +72,33 @@ func join(o ServerOptions, route string) string {
 	return path.Join(o.PathPrefix, route)
 }
 
+// imageRoutes defines the image processing endpoints and their operations, in registration order
+var imageRoutes = []struct {
+	Name      string
+	Operation Operation
+}{
+	{"resize", Resize},
+	{"enlarge", Enlarge},
+	{"extract", Extract},
+	{"crop", Crop},
+	{"rotate", Rotate},
+	{"flip", Flip},
+	{"flop", Flop},
+	{"thumbnail", Thumbnail},
+	{"zoom", Zoom},
+	{"convert", Convert},
+	{"watermark", Watermark},
+	{"watermarkimage", WatermarkImage},
+	{"blur", Blur},
+	{"sharpen", Sharpen},
+	{"info", Info},
+	{"palette", Palette},
+	{"lqip", LQIP},
+	{"hash", Hash},
+	{"srcset", Srcset},
+	{"pipeline", Pipeline},
+}
+
 // NewServerMux creates a new HTTP server route multiplexer.
 func NewServerMux(o ServerOptions) http.Handler {
 	mux := http.NewServeMux()
@@ -67,20 +106,22 @@ func NewServerMux(o ServerOptions) http.Handler {
 	mux.Handle(join(o, "/"), Middleware(indexController, o))
 	mux.Handle(join(o, "/form"), Middleware(formController, o))
 	mux.Handle(join(o, "/health"), Middleware(healthController, o))
+	mux.Handle(join(o, "/openapi.json"), Middleware(openapiController(o), o))
 
 	image := ImageMiddleware(o)
-	mux.Handle(join(o, "/resize"), image(Resize))
-	mux.Handle(join(o, "/enlarge"), image(Enlarge))
-	mux.Handle(join(o, "/extract"), image(Extract))
-	mux.Handle(join(o, "/crop"), image(Crop))
-	mux.Handle(join(o, "/rotate"), image(Rotate))
-	mux.Handle(join(o, "/flip"), image(Flip))
-	mux.Handle(join(o, "/flop"), image(Flop))
-	mux.Handle(join(o, "/thumbnail"), image(Thumbnail))
-	mux.Handle(join(o, "/zoom"), image(Zoom))
-	mux.Handle(join(o, "/convert"), image(Convert))
-	mux.Handle(join(o, "/watermark"), image(Watermark))
-	mux.Handle(join(o, "/info"), image(Info))
+	for _, route := range imageRoutes {
+		if route.Name == "pipeline" {
+			// Pipeline operations may be also defined as JSON body
+			mux.Handle(join(o, "/pipeline"), validateImage(Middleware(pipelineController(o), o), o))
+		} else {
+			mux.Handle(join(o, "/"+route.Name), image(route.Operation))
+		}
+		// Path-style URLs, such as /resize/w:300,h:200/path/to/image.jpg
+		if supportsPathStyleURL(route.Name) {
+			mux.Handle(join(o, "/"+route.Name)+"/", Middleware(pathController(o, route.Name, route.Operation), o))
+		}
+	}
+	mux.Handle(join(o, "/sheet"), validateImage(Middleware(sheetController(o), o), o))
 
 	return mux
 }
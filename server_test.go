@@ -1,11 +1,16 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	neturl "net/url"
 	"os"
 	"path"
 	"strings"
@@ -70,10 +75,540 @@ func TestCrop(t *testing.T) {
 	}
 }
 
+func TestSmartCrop(t *testing.T) {
+	ts := testServer(controller(Crop))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?width=300&height=300&gravity=smart"
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = assertSize(image, 300, 300)
+	if err != nil {
+		t.Error(err)
+	}
+
+	area := strings.Split(res.Header.Get("X-Crop-Area"), ",")
+	if len(area) != 4 || area[1] != "0" || area[2] != "1080" || area[3] != "1080" {
+		t.Errorf("Invalid crop area header: %s", res.Header.Get("X-Crop-Area"))
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
 func TestResize(t *testing.T) {
 	ts := testServer(controller(Resize))
 	buf := readFile("large.jpg")
-	url := ts.URL + "?width=300"
+	url := ts.URL + "?width=300"
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) == 0 {
+		t.Fatalf("Empty response body")
+	}
+
+	err = assertSize(image, 300, 1080)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestEnlarge(t *testing.T) {
+	ts := testServer(controller(Enlarge))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?width=300&height=200"
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) == 0 {
+		t.Fatalf("Empty response body")
+	}
+
+	err = assertSize(image, 300, 200)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestExtract(t *testing.T) {
+	ts := testServer(controller(Extract))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?top=100&left=100&areawidth=200&areaheight=120"
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) == 0 {
+		t.Fatalf("Empty response body")
+	}
+
+	err = assertSize(image, 200, 120)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	ts := testServer(controller(Pipeline))
+	buf := readFile("large.jpg")
+	operations := `[{"operation": "crop", "params": {"width": 300, "height": 260}}, {"operation": "convert", "params": {"type": "png"}}]`
+	url := ts.URL + "?operations=" + neturl.QueryEscape(operations)
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) == 0 {
+		t.Fatalf("Empty response body")
+	}
+
+	err = assertSize(image, 300, 260)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bimg.DetermineImageTypeName(image) != "png" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestPipelineInvalidOperation(t *testing.T) {
+	ts := testServer(controller(Pipeline))
+	buf := readFile("large.jpg")
+	operations := `[{"operation": "crop", "params": {"width": 300}}, {"operation": "info"}]`
+	url := ts.URL + "?operations=" + neturl.QueryEscape(operations)
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 400 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if strings.Contains(string(body), "step #2 (info)") == false {
+		t.Fatalf("Invalid error message: %s", body)
+	}
+}
+
+func TestPipelineJSONBody(t *testing.T) {
+	opts := ServerOptions{Mount: "fixtures"}
+	LoadSources(opts)
+
+	ts := httptest.NewServer(validateImage(Middleware(pipelineController(opts), opts), opts))
+	operations := `[{"operation": "crop", "params": {"width": 300, "height": 260}}, {"operation": "convert", "params": {"type": "png"}}]`
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/pipeline?file=large.jpg", "application/json", strings.NewReader(operations))
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := assertSize(image, 300, 260); err != nil {
+		t.Error(err)
+	}
+	if bimg.DetermineImageTypeName(image) != "png" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestPipelineInvalidJSON(t *testing.T) {
+	ts := testServer(controller(Pipeline))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?operations=" + neturl.QueryEscape(`[{"operation": "crop"`)
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 400 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if !strings.Contains(string(body), "must be a JSON array of operations: unexpected end of JSON input") {
+		t.Fatalf("Invalid error message: %s", body)
+	}
+}
+
+func TestBlur(t *testing.T) {
+	ts := testServer(controller(Blur))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?sigma=10&width=300"
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) == 0 {
+		t.Fatalf("Empty response body")
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestAutoImageType(t *testing.T) {
+	ts := testServer(controller(Resize))
+	url := ts.URL + "?width=300&type=auto"
+	defer ts.Close()
+
+	cases := []struct {
+		accept   string
+		expected string
+	}{
+		{"image/webp,image/*,*/*;q=0.8", "webp"},
+		{"image/*,*/*;q=0.8", "jpeg"},
+		{"", "jpeg"},
+	}
+
+	for _, test := range cases {
+		req, _ := http.NewRequest("POST", url, readFile("large.jpg"))
+		req.Header.Set("Content-Type", "image/jpeg")
+		req.Header.Set("Accept", test.accept)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode != 200 {
+			t.Fatalf("Invalid response status: %s", res.Status)
+		}
+		if res.Header.Get("Vary") != "Accept" {
+			t.Errorf("Invalid Vary header: %s", res.Header.Get("Vary"))
+		}
+
+		image, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bimg.DetermineImageTypeName(image) != test.expected {
+			t.Errorf("Invalid image type for accept %s: %s", test.accept, bimg.DetermineImageTypeName(image))
+		}
+	}
+}
+
+func TestPalette(t *testing.T) {
+	ts := testServer(controller(Palette))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?colors=3"
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+	if res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	palette := ImagePalette{}
+	if err := json.NewDecoder(res.Body).Decode(&palette); err != nil {
+		t.Fatal(err)
+	}
+	if len(palette.Palette) != 3 || palette.Dominant.Hex == "" {
+		t.Errorf("Invalid palette: %#v", palette)
+	}
+}
+
+func TestLQIP(t *testing.T) {
+	ts := testServer(controller(LQIP))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?kind=blurhash&xcomponents=5&ycomponents=4"
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+	if res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	preview := ImagePreview{}
+	if err := json.NewDecoder(res.Body).Decode(&preview); err != nil {
+		t.Fatal(err)
+	}
+	if preview.Kind != "blurhash" || len(preview.Value) != 6+2*19 {
+		t.Errorf("Invalid blurhash: %#v", preview)
+	}
+	if preview.Width != 1920 || preview.Height != 1080 {
+		t.Errorf("Invalid image size: %dx%d", preview.Width, preview.Height)
+	}
+}
+
+func TestLQIPDataURI(t *testing.T) {
+	ts := testServer(controller(LQIP))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?kind=datauri&type=webp"
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	preview := ImagePreview{}
+	if err := json.NewDecoder(res.Body).Decode(&preview); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(preview.Value, "data:image/webp;base64,") {
+		t.Errorf("Invalid data URI: %s", preview.Value)
+	}
+}
+
+func TestHash(t *testing.T) {
+	ts := testServer(controller(Hash))
+	buf := readFile("large.jpg")
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	hashes := ImageHashes{}
+	if err := json.NewDecoder(res.Body).Decode(&hashes); err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes.AHash) != 16 || len(hashes.DHash) != 16 || len(hashes.PHash) != 16 {
+		t.Errorf("Invalid hashes: %#v", hashes)
+	}
+}
+
+func TestMountDirectoryHashCompare(t *testing.T) {
+	opts := ServerOptions{Mount: "fixtures"}
+	fn := ImageMiddleware(opts)(Hash)
+	LoadSources(opts)
+
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "?file=large.jpg&compare=large.jpg&algorithm=dhash"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %d", res.StatusCode)
+	}
+
+	comparison := ImageHashComparison{}
+	if err := json.NewDecoder(res.Body).Decode(&comparison); err != nil {
+		t.Fatal(err)
+	}
+	if comparison.Algorithm != "dhash" || comparison.Distance != 0 || comparison.Verdict != "identical" {
+		t.Errorf("Invalid comparison: %#v", comparison)
+	}
+}
+
+func TestSrcset(t *testing.T) {
+	ts := testServer(controller(Srcset))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?widths=320,640,4096&types=jpeg,png&output=zip"
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+	if res.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Widths larger than the image width are ignored
+	if len(reader.File) != 4 {
+		t.Fatalf("Invalid number of variants: %d", len(reader.File))
+	}
+	if reader.File[3].Name != "640w.png" {
+		t.Errorf("Invalid variant name: %s", reader.File[3].Name)
+	}
+}
+
+func TestSrcsetEncoderParams(t *testing.T) {
+	ts := testServer(controller(Srcset))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?widths=320&types=webp&nearlossless=true"
+	defer ts.Close()
+
+	// Encoder params apply to the variants output type, instead of the source image type
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	manifest := struct {
+		Variants []SrcsetVariant `json:"variants"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Variants) != 1 || manifest.Variants[0].Mime != "image/webp" {
+		t.Errorf("Invalid variants: %#v", manifest.Variants)
+	}
+}
+
+func TestSheet(t *testing.T) {
+	ts := testServer(sheetController(ServerOptions{}))
+	url := ts.URL + "?width=100&height=100&gap=10&columns=2&output=json&caption=large&caption=medium"
+	defer ts.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, name := range []string{"large.jpg", "medium.jpg", "test.png"} {
+		part, _ := writer.CreateFormFile("file", name)
+		buf, _ := ioutil.ReadFile(path.Join("fixtures", name))
+		part.Write(buf)
+	}
+	writer.Close()
+
+	res, err := http.Post(url, writer.FormDataContentType(), body)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	sheet := SheetMap{}
+	if err := json.NewDecoder(res.Body).Decode(&sheet); err != nil {
+		t.Fatal(err)
+	}
+	if sheet.Width != 230 || sheet.Height != 2*(100+sheetCaptionHeight+10)+10 || sheet.Mime != "image/png" {
+		t.Errorf("Invalid sheet: %dx%d %s", sheet.Width, sheet.Height, sheet.Mime)
+	}
+	if len(sheet.Tiles) != 3 || sheet.Tiles[2].Name != "test.png" || sheet.Tiles[0].Width != 100 {
+		t.Errorf("Invalid sheet tiles: %#v", sheet.Tiles)
+	}
+}
+
+func TestDPR(t *testing.T) {
+	ts := testServer(controller(Resize))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?width=200&height=100&dpr=2"
 	defer ts.Close()
 
 	res, err := http.Post(url, "image/jpeg", buf)
@@ -84,29 +619,60 @@ func TestResize(t *testing.T) {
 	if res.StatusCode != 200 {
 		t.Fatalf("Invalid response status: %s", res.Status)
 	}
+	if res.Header.Get("Content-DPR") != "2" {
+		t.Fatalf("Invalid Content-DPR header: %s", res.Header.Get("Content-DPR"))
+	}
 
 	image, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(image) == 0 {
-		t.Fatalf("Empty response body")
-	}
 
-	err = assertSize(image, 300, 1080)
+	err = assertSize(image, 400, 200)
 	if err != nil {
 		t.Error(err)
 	}
+}
+
+func TestDPRJSONResponse(t *testing.T) {
+	ts := testServer(controller(Info))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?dpr=2"
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
 
-	if bimg.DetermineImageTypeName(image) != "jpeg" {
-		t.Fatalf("Invalid image type")
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+	if res.Header.Get("Content-DPR") != "" {
+		t.Errorf("Content-DPR header must not be sent in JSON responses: %s", res.Header.Get("Content-DPR"))
 	}
 }
 
-func TestEnlarge(t *testing.T) {
-	ts := testServer(controller(Enlarge))
+func TestInvalidDPR(t *testing.T) {
+	ts := testServer(controller(Resize))
 	buf := readFile("large.jpg")
-	url := ts.URL + "?width=300&height=200"
+	url := ts.URL + "?width=200&dpr=0.5"
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 400 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+}
+
+func TestRelativeDimensions(t *testing.T) {
+	ts := testServer(controller(Crop))
+	buf := readFile("large.jpg")
+	url := ts.URL + "?width=50p&aspect=1:1"
 	defer ts.Close()
 
 	res, err := http.Post(url, "image/jpeg", buf)
@@ -122,24 +688,41 @@ func TestEnlarge(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(image) == 0 {
-		t.Fatalf("Empty response body")
-	}
 
-	err = assertSize(image, 300, 200)
+	err = assertSize(image, 960, 960)
 	if err != nil {
 		t.Error(err)
 	}
+}
 
-	if bimg.DetermineImageTypeName(image) != "jpeg" {
-		t.Fatalf("Invalid image type")
+func TestOutputLimits(t *testing.T) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		imageHandler(w, r, buf, Enlarge, ServerOptions{MaxOutputDimension: 2000})
+	}
+	ts := testServer(fn)
+	buf := readFile("large.jpg")
+	url := ts.URL + "?width=4000&height=3000"
+	defer ts.Close()
+
+	res, err := http.Post(url, "image/jpeg", buf)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+
+	if res.StatusCode != 422 {
+		t.Fatalf("Invalid response status: %s", res.Status)
 	}
 }
 
-func TestExtract(t *testing.T) {
-	ts := testServer(controller(Extract))
+func TestStrictParams(t *testing.T) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		imageHandler(w, r, buf, Resize, ServerOptions{StrictParams: true})
+	}
+	ts := testServer(fn)
 	buf := readFile("large.jpg")
-	url := ts.URL + "?top=100&left=100&areawidth=200&areaheight=120"
+	url := ts.URL + "?width=-300&quality=abc&foo=bar"
 	defer ts.Close()
 
 	res, err := http.Post(url, "image/jpeg", buf)
@@ -147,25 +730,47 @@ func TestExtract(t *testing.T) {
 		t.Fatal("Cannot perform the request")
 	}
 
-	if res.StatusCode != 200 {
+	if res.StatusCode != 400 {
 		t.Fatalf("Invalid response status: %s", res.Status)
 	}
 
-	image, err := ioutil.ReadAll(res.Body)
-	if err != nil {
+	var body Error
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
 		t.Fatal(err)
 	}
-	if len(image) == 0 {
-		t.Fatalf("Empty response body")
+	if len(body.Errors) != 3 {
+		t.Fatalf("Invalid number of param errors: %#v", body.Errors)
 	}
+	if body.Errors[0].Param != "foo" || body.Errors[1].Param != "quality" || body.Errors[2].Param != "width" {
+		t.Errorf("Invalid param errors: %#v", body.Errors)
+	}
+}
 
-	err = assertSize(image, 200, 120)
+func TestOpenAPI(t *testing.T) {
+	ts := testServer(openapiController(ServerOptions{PathPrefix: "/"}))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
 	if err != nil {
-		t.Error(err)
+		t.Fatal("Cannot perform the request")
 	}
 
-	if bimg.DetermineImageTypeName(image) != "jpeg" {
-		t.Fatalf("Invalid image type")
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+	if res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	var spec struct {
+		OpenAPI string                 `json:"openapi"`
+		Paths   map[string]interface{} `json:"paths"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.OpenAPI != openapiVersion || spec.Paths["/resize"] == nil {
+		t.Errorf("Invalid OpenAPI document: %#v", spec)
 	}
 }
 
@@ -233,6 +838,51 @@ func TestInvalidRemoteHTTPSource(t *testing.T) {
 	}
 }
 
+func TestS3SourceNotFound(t *testing.T) {
+	tsStorage := newS3TestServer(t, "/images/large.jpg", []byte("foo"))
+	defer tsStorage.Close()
+
+	config := s3TestConfig
+	config.Endpoint = tsStorage.URL
+	opts := ServerOptions{EnableS3Source: true, S3: config, ApiKey: "foo"}
+	fn := ImageMiddleware(opts)(Crop)
+	LoadSources(opts)
+
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "?width=200&height=200&bucket=images&object=missing.jpg&key=foo")
+	if err != nil {
+		t.Fatal("Request failed")
+	}
+	if res.StatusCode != 404 {
+		t.Fatalf("Invalid response status: %d", res.StatusCode)
+	}
+}
+
+func TestGCSSourceNotFound(t *testing.T) {
+	tsStorage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(404)
+	}))
+	defer tsStorage.Close()
+
+	opts := ServerOptions{EnableGCSSource: true, GCS: GCSConfig{Endpoint: tsStorage.URL}}
+	fn := ImageMiddleware(opts)(Crop)
+	LoadSources(opts)
+
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "?width=200&height=200&url=gs://images/missing.jpg"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Request failed")
+	}
+	if res.StatusCode != 404 {
+		t.Fatalf("Invalid response status: %d", res.StatusCode)
+	}
+}
+
 func TestMountDirectory(t *testing.T) {
 	opts := ServerOptions{Mount: "fixtures"}
 	fn := ImageMiddleware(opts)(Crop)
@@ -268,6 +918,151 @@ func TestMountDirectory(t *testing.T) {
 	}
 }
 
+func TestMountDirectoryWatermarkImage(t *testing.T) {
+	opts := ServerOptions{Mount: "fixtures"}
+	fn := ImageMiddleware(opts)(WatermarkImage)
+	LoadSources(opts)
+
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "?file=large.jpg&image=test.png&scale=0.2&gravity=south&margin=10"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %d", res.StatusCode)
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = assertSize(image, 1920, 1080)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestMountDirectoryWatermarkImageInvalidPath(t *testing.T) {
+	opts := ServerOptions{Mount: "fixtures"}
+	fn := ImageMiddleware(opts)(WatermarkImage)
+	LoadSources(opts)
+
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "?file=large.jpg&image=../../test.png"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 400 {
+		t.Fatalf("Invalid response status: %d", res.StatusCode)
+	}
+}
+
+func TestPathStyleURLMount(t *testing.T) {
+	opts := ServerOptions{Mount: "fixtures", PathPrefix: "/"}
+	LoadSources(opts)
+
+	ts := httptest.NewServer(NewServerMux(opts))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/crop/w:200,h:200/large.jpg")
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %d", res.StatusCode)
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := assertSize(image, 200, 200); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPathStyleURLOrigin(t *testing.T) {
+	tsImage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/images/large.jpg" {
+			w.WriteHeader(404)
+			return
+		}
+		buf, _ := ioutil.ReadFile("fixtures/large.jpg")
+		w.Write(buf)
+	}))
+	defer tsImage.Close()
+
+	opts := ServerOptions{PathOrigin: tsImage.URL + "/images", PathPrefix: "/"}
+	ts := httptest.NewServer(NewServerMux(opts))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/resize/w:300,type:png/large.jpg")
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %d", res.StatusCode)
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bimg.DetermineImageTypeName(image) != "png" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestPathStyleURLErrors(t *testing.T) {
+	opts := ServerOptions{Mount: "fixtures", PathPrefix: "/"}
+	LoadSources(opts)
+
+	ts := httptest.NewServer(NewServerMux(opts))
+	defer ts.Close()
+
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{"/resize/w:300", 400},
+		{"/resize/foo:300/large.jpg", 400},
+		{"/resize/w:300/missing.jpg", 400},
+		{"/pipeline/-/large.jpg", 404},
+	}
+
+	for _, test := range cases {
+		res, err := http.Get(ts.URL + test.path)
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode != test.status {
+			t.Errorf("Invalid response status for %s: %d", test.path, res.StatusCode)
+		}
+	}
+
+	tsNoMount := httptest.NewServer(NewServerMux(ServerOptions{PathPrefix: "/"}))
+	defer tsNoMount.Close()
+
+	res, err := http.Get(tsNoMount.URL + "/resize/w:300/large.jpg")
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 405 {
+		t.Errorf("Invalid response status: %d", res.StatusCode)
+	}
+}
+
 func TestMountInvalidDirectory(t *testing.T) {
 	fn := ImageMiddleware(ServerOptions{Mount: "_invalid_"})(Crop)
 	ts := httptest.NewServer(fn)
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"path"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+const (
+	sheetMaxTiles      = 100
+	sheetMaxCell       = 2048
+	sheetMaxGap        = 256
+	sheetMaxPixels     = 8192 * 8192
+	sheetDefaultCell   = 200
+	sheetCaptionHeight = 24
+	sheetCaptionMargin = 4
+	sheetCaptionField  = "caption"
+)
+
+// SheetImage represents a single image source to be composed in a sheet
+type SheetImage struct {
+	Name string
+	Buf  []byte
+}
+
+// SheetTile represents the position and size of an image in the sheet
+type SheetTile struct {
+	Name   string `json:"name"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// SheetMap represents the sheet coordinates map, useful for CSS sprites
+type SheetMap struct {
+	Width  int         `json:"width"`
+	Height int         `json:"height"`
+	Mime   string      `json:"mime"`
+	Body   string      `json:"body"`
+	Tiles  []SheetTile `json:"tiles"`
+}
+
+// sheetLayout defines the sheet grid dimensions
+type sheetLayout struct {
+	Columns    int
+	Rows       int
+	CellWidth  int
+	CellHeight int
+	Caption    int
+	Gap        int
+}
+
+// newSheetLayout creates the sheet grid of the given number of tiles, rejecting the cell, gap and
+// columns params out of range, and the sheets exceeding the maximum canvas size, since the whole
+// canvas is allocated in memory.
+func newSheetLayout(tiles int, o ImageOptions) (sheetLayout, error) {
+	layout := sheetLayout{CellWidth: o.Width, CellHeight: o.Height, Gap: o.Gap, Columns: o.Columns}
+	if layout.CellWidth < 0 || layout.CellHeight < 0 || layout.CellWidth > sheetMaxCell || layout.CellHeight > sheetMaxCell {
+		return layout, NewError(fmt.Sprintf("Invalid params: width and height must be between 1 and %d", sheetMaxCell), BadRequest)
+	}
+	if layout.Gap < 0 || layout.Gap > sheetMaxGap {
+		return layout, NewError(fmt.Sprintf("Invalid param: gap must be between 0 and %d", sheetMaxGap), BadRequest)
+	}
+	if layout.Columns < 0 || layout.Columns > sheetMaxTiles {
+		return layout, NewError(fmt.Sprintf("Invalid param: columns must be between 1 and %d", sheetMaxTiles), BadRequest)
+	}
+
+	if layout.CellWidth == 0 && layout.CellHeight == 0 {
+		layout.CellWidth, layout.CellHeight = sheetDefaultCell, sheetDefaultCell
+	}
+	if layout.CellWidth == 0 {
+		layout.CellWidth = layout.CellHeight
+	}
+	if layout.CellHeight == 0 {
+		layout.CellHeight = layout.CellWidth
+	}
+	if layout.Columns == 0 {
+		layout.Columns = int(math.Ceil(math.Sqrt(float64(tiles))))
+	}
+	if layout.Columns > tiles {
+		layout.Columns = tiles
+	}
+	layout.Rows = (tiles + layout.Columns - 1) / layout.Columns
+
+	for _, caption := range o.Captions {
+		if caption != "" {
+			layout.Caption = sheetCaptionHeight
+			break
+		}
+	}
+
+	// Bounded cells, gap and tiles cannot overflow the sheet size
+	width, height := layout.Size()
+	if pixels := int64(width) * int64(height); pixels > sheetMaxPixels {
+		return layout, NewError(fmt.Sprintf("Sheet size of %d pixels exceeds the maximum allowed of %d pixels", pixels, sheetMaxPixels), Unprocessable)
+	}
+	return layout, nil
+}
+
+// Size returns the whole sheet size
+func (l sheetLayout) Size() (int, int) {
+	width := l.Columns*(l.CellWidth+l.Gap) + l.Gap
+	height := l.Rows*(l.CellHeight+l.Caption+l.Gap) + l.Gap
+	return width, height
+}
+
+// Cell returns the top left position of the cell at the given index
+func (l sheetLayout) Cell(index int) (int, int) {
+	column, row := index%l.Columns, index/l.Columns
+	return l.Gap + column*(l.CellWidth+l.Gap), l.Gap + row*(l.CellHeight+l.Caption+l.Gap)
+}
+
+// ContactSheet composes the given images in a grid, fitting each image in its cell,
+// with an optional caption below. It returns the sheet image, or its coordinates map
+// as JSON, including the base64 encoded sheet image, if the json output is defined.
+func ContactSheet(images []SheetImage, o ImageOptions) (Image, error) {
+	if len(images) == 0 {
+		return Image{}, ErrMissingImageSource
+	}
+	if err := checkSheetImages(len(images)); err != nil {
+		return Image{}, err
+	}
+	if o.Output != "" && o.Output != "json" {
+		return Image{}, NewError("Invalid param: output must be json", BadRequest)
+	}
+
+	outputType := ImageType(o.Type)
+	if o.Type == "" {
+		outputType = bimg.PNG
+	}
+
+	background := color.NRGBA{}
+	if len(o.Background) > 2 {
+		background = color.NRGBA{o.Background[0], o.Background[1], o.Background[2], 255}
+	} else if outputType == bimg.JPEG {
+		background = color.NRGBA{255, 255, 255, 255}
+	}
+
+	layout, err := newSheetLayout(len(images), o)
+	if err != nil {
+		return Image{}, err
+	}
+
+	width, height := layout.Size()
+	if err := CheckOutputLimits(width, height, o.MaxOutputDimension); err != nil {
+		return Image{}, err
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(background), image.ZP, draw.Src)
+
+	tiles := []SheetTile{}
+	for i, source := range images {
+		buf, err := decodeImage(source.Buf)
+		if err != nil {
+			return Image{}, NewError(fmt.Sprintf("Cannot decode image #%d: %s", i+1, err.Error()), BadRequest)
+		}
+
+		tile, err := Process(buf, bimg.Options{
+			Width:        layout.CellWidth,
+			Height:       layout.CellHeight,
+			NoAutoRotate: o.NoRotation,
+			Type:         bimg.PNG,
+		})
+		if err != nil {
+			return Image{}, NewError(fmt.Sprintf("Cannot process image #%d: %s", i+1, err.Error()), BadRequest)
+		}
+
+		img, err := png.Decode(bytes.NewReader(tile.Body))
+		if err != nil {
+			return Image{}, NewError("Cannot decode image tile: "+err.Error(), InternalError)
+		}
+
+		// Center the image in its cell
+		bounds := img.Bounds()
+		tw, th := int(math.Min(float64(bounds.Dx()), float64(layout.CellWidth))), int(math.Min(float64(bounds.Dy()), float64(layout.CellHeight)))
+		cx, cy := layout.Cell(i)
+		x, y := cx+(layout.CellWidth-tw)/2, cy+(layout.CellHeight-th)/2
+		draw.Draw(canvas, image.Rect(x, y, x+tw, y+th), img, bounds.Min, draw.Over)
+
+		tiles = append(tiles, SheetTile{Name: source.Name, X: x, Y: y, Width: tw, Height: th})
+
+		if i < len(o.Captions) && o.Captions[i] != "" {
+			caption, err := renderCaption(o.Captions[i], layout.CellWidth, layout.Caption, background, o)
+			if err != nil {
+				return Image{}, NewError("Cannot render caption: "+err.Error(), InternalError)
+			}
+			rect := image.Rect(cx, cy+layout.CellHeight, cx+layout.CellWidth, cy+layout.CellHeight+layout.Caption)
+			draw.Draw(canvas, rect, caption, caption.Bounds().Min, draw.Over)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, canvas); err != nil {
+		return Image{}, NewError("Cannot encode image: "+err.Error(), InternalError)
+	}
+
+	sheet, err := EncodeImage(buf.Bytes(), outputType, o.Quality, 0, ImageOptions{Compression: o.Compression})
+	if err != nil {
+		return Image{}, err
+	}
+
+	if o.Output != "json" {
+		return sheet, nil
+	}
+
+	body, _ := json.Marshal(SheetMap{
+		Width:  width,
+		Height: height,
+		Mime:   sheet.Mime,
+		Body:   base64.StdEncoding.EncodeToString(sheet.Body),
+		Tiles:  tiles,
+	})
+	return Image{Body: body, Mime: "application/json"}, nil
+}
+
+// renderCaption renders the caption text on a solid strip via libvips text watermark
+func renderCaption(text string, width, height int, background color.NRGBA, o ImageOptions) (image.Image, error) {
+	// Text is always rendered on an opaque background for legibility
+	if background.A < 255 {
+		background = color.NRGBA{255, 255, 255, 255}
+	}
+
+	strip := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(strip, strip.Bounds(), image.NewUniform(background), image.ZP, draw.Src)
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, strip); err != nil {
+		return nil, err
+	}
+
+	font := o.Font
+	if font == "" {
+		font = "sans 10"
+	}
+
+	watermark := bimg.Watermark{
+		Text:        text,
+		Font:        font,
+		DPI:         o.DPI,
+		Width:       width - 2*sheetCaptionMargin,
+		Margin:      sheetCaptionMargin,
+		Opacity:     1,
+		NoReplicate: true,
+	}
+	if len(o.Color) > 2 {
+		watermark.Background = bimg.Color{o.Color[0], o.Color[1], o.Color[2]}
+	}
+
+	caption, err := Process(buf.Bytes(), bimg.Options{Type: bimg.PNG, Watermark: watermark})
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(caption.Body))
+}
+
+// readSheetImages reads the sheet images from the multipart form files, for POST requests,
+// or from the url and file query params, for GET requests, applying the image sources rules.
+// checkSheetImages verifies the number of sheet images, which is checked before reading them too
+func checkSheetImages(count int) error {
+	if count > sheetMaxTiles {
+		return NewError(fmt.Sprintf("Invalid params: up to %d images are allowed", sheetMaxTiles), BadRequest)
+	}
+	return nil
+}
+
+func readSheetImages(r *http.Request, o ServerOptions) ([]SheetImage, error) {
+	images := []SheetImage{}
+
+	if r.Method == "POST" {
+		if !isSourceEnabled(ImageSourceTypeBody, o) {
+			return nil, sourceNotEnabledErrors[ImageSourceTypeBody]
+		}
+		if !isFormBody(r) {
+			return nil, errors.New("multipart form payload is required")
+		}
+		if err := r.ParseMultipartForm(maxMemory); err != nil {
+			return nil, err
+		}
+
+		files := r.MultipartForm.File[formField(r)]
+		if err := checkSheetImages(len(files)); err != nil {
+			return nil, err
+		}
+
+		for _, header := range files {
+			file, err := header.Open()
+			if err != nil {
+				return nil, err
+			}
+			buf, err := ioutil.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, SheetImage{Name: header.Filename, Buf: buf})
+		}
+		return images, nil
+	}
+
+	query := r.URL.Query()
+	if err := checkSheetImages(len(query["file"]) + len(query["url"])); err != nil {
+		return nil, err
+	}
+
+	for _, value := range query["url"] {
+		if kind := urlSourceType(value); !isSourceEnabled(kind, o) {
+			return nil, sourceNotEnabledErrors[kind]
+		}
+	}
+	if len(query["file"]) > 0 && !isSourceEnabled(ImageSourceTypeFileSystem, o) {
+		return nil, sourceNotEnabledErrors[ImageSourceTypeFileSystem]
+	}
+
+	for _, value := range query["file"] {
+		buf, err := readImageFromSource(r, ImageSourceTypeFileSystem, "file", value)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, SheetImage{Name: path.Base(value), Buf: buf})
+	}
+	for _, value := range query["url"] {
+		buf, err := readImageFromSource(r, urlSourceType(value), "url", value)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, SheetImage{Name: path.Base(value), Buf: buf})
+	}
+
+	return images, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestSheetLayout(t *testing.T) {
+	layout, err := newSheetLayout(5, ImageOptions{Width: 100, Gap: 10, Captions: []string{"", "foo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if layout.Columns != 3 || layout.Rows != 2 {
+		t.Fatalf("Invalid grid: %dx%d", layout.Columns, layout.Rows)
+	}
+	if layout.CellWidth != 100 || layout.CellHeight != 100 || layout.Caption != sheetCaptionHeight {
+		t.Fatalf("Invalid cell size: %#v", layout)
+	}
+
+	width, height := layout.Size()
+	if width != 340 || height != 10+2*(100+sheetCaptionHeight+10) {
+		t.Errorf("Invalid sheet size: %dx%d", width, height)
+	}
+
+	x, y := layout.Cell(4)
+	if x != 120 || y != 10+100+sheetCaptionHeight+10 {
+		t.Errorf("Invalid cell position: %d,%d", x, y)
+	}
+}
+
+func TestSheetLayoutDefaults(t *testing.T) {
+	layout, err := newSheetLayout(2, ImageOptions{Columns: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if layout.Columns != 2 || layout.Rows != 1 {
+		t.Errorf("Invalid grid: %dx%d", layout.Columns, layout.Rows)
+	}
+	if layout.CellWidth != sheetDefaultCell || layout.CellHeight != sheetDefaultCell || layout.Caption != 0 {
+		t.Errorf("Invalid cell size: %#v", layout)
+	}
+}
+
+func TestSheetLayoutLimits(t *testing.T) {
+	cases := []struct {
+		tiles int
+		opts  ImageOptions
+		code  uint8
+	}{
+		{1, ImageOptions{Width: sheetMaxCell + 1}, BadRequest},
+		{1, ImageOptions{Width: -100}, BadRequest},
+		{1, ImageOptions{Gap: sheetMaxGap + 1}, BadRequest},
+		{2, ImageOptions{Columns: -1}, BadRequest},
+		{sheetMaxTiles, ImageOptions{Width: sheetMaxCell, Height: sheetMaxCell}, Unprocessable},
+	}
+
+	for _, test := range cases {
+		_, err := newSheetLayout(test.tiles, test.opts)
+		if xerr, ok := err.(Error); !ok || xerr.Code != test.code {
+			t.Errorf("Invalid error for %#v: %#v", test.opts, err)
+		}
+	}
+
+	if _, err := newSheetLayout(sheetMaxTiles, ImageOptions{Width: 800, Height: 800}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestReadSheetImagesForm(t *testing.T) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, name := range []string{"foo.png", "bar.png"} {
+		part, _ := writer.CreateFormFile("file", name)
+		part.Write([]byte(name))
+	}
+	writer.Close()
+
+	r, _ := http.NewRequest("POST", "http://foo/sheet", body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+
+	images, err := readSheetImages(r, ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 || images[1].Name != "bar.png" || string(images[1].Buf) != "bar.png" {
+		t.Errorf("Invalid images: %#v", images)
+	}
+}
+
+func TestReadSheetImagesMount(t *testing.T) {
+	opts := ServerOptions{Mount: "fixtures"}
+	LoadSources(opts)
+
+	r, _ := http.NewRequest("GET", "http://foo/sheet?file=large.jpg&file=test.png", nil)
+	images, err := readSheetImages(r, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 || images[0].Name != "large.jpg" || images[1].Name != "test.png" || len(images[1].Buf) == 0 {
+		t.Errorf("Invalid images: %d", len(images))
+	}
+
+	r, _ = http.NewRequest("GET", "http://foo/sheet?url=http://foo/bar.jpg", nil)
+	if _, err := readSheetImages(r, opts); err == nil {
+		t.Error("Disabled URL source must fail")
+	}
+}
+
+func TestReadSheetImagesLimit(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+
+	opts := ServerOptions{EnableURLSource: true}
+	LoadSources(opts)
+
+	query := url.Values{}
+	for i := 0; i <= sheetMaxTiles; i++ {
+		query.Add("url", ts.URL+"/image.jpg")
+	}
+
+	r, _ := http.NewRequest("GET", "http://foo/sheet?"+query.Encode(), nil)
+	_, err := readSheetImages(r, opts)
+	if xerr, ok := err.(Error); !ok || xerr.Code != BadRequest {
+		t.Errorf("Invalid error: %#v", err)
+	}
+	if requests > 0 {
+		t.Errorf("Images must not be fetched: %d requests", requests)
+	}
+}
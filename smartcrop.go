@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// Size of the longest side of the downscaled image used to find the most salient area
+const smartCropAnalysisSize = 256
+
+// CropArea represents an image area in pixels, relative to the oriented source image
+type CropArea struct {
+	Left   int
+	Top    int
+	Width  int
+	Height int
+}
+
+// String returns the area in the format used by the X-Crop-Area response header
+func (a CropArea) String() string {
+	return fmt.Sprintf("%d,%d,%d,%d", a.Left, a.Top, a.Width, a.Height)
+}
+
+// SmartCrop crops the image following its most salient region based on the luminance entropy,
+// then resizes it to the requested dimensions. The chosen area is exposed as response header.
+// If width or height are missing, the source image size is used for that dimension.
+func SmartCrop(buf []byte, opts bimg.Options) (Image, error) {
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return Image{}, NewError("Cannot retrieve image metadata: "+err.Error(), BadRequest)
+	}
+
+	width, height := orientedSize(meta, opts)
+	targetWidth, targetHeight := opts.Width, opts.Height
+	if targetWidth == 0 {
+		targetWidth = width
+	}
+	if targetHeight == 0 {
+		targetHeight = height
+	}
+
+	// Analyze a tiny lossless version of the image, applying the same orientation transformations
+	img, err := sampleImage(buf, smartCropAnalysisSize, bimg.Options{
+		Rotate:       opts.Rotate,
+		Flip:         opts.Flip,
+		Flop:         opts.Flop,
+		NoAutoRotate: opts.NoAutoRotate,
+	})
+	if err != nil {
+		return Image{}, err
+	}
+
+	area := findSmartCropArea(img, width, height, targetWidth, targetHeight)
+
+	// Extract the salient area losslessly, applying orientation transformations only once
+	extracted, err := Process(buf, bimg.Options{
+		Top:          area.Top,
+		Left:         area.Left,
+		AreaWidth:    area.Width,
+		AreaHeight:   area.Height,
+		Rotate:       opts.Rotate,
+		Flip:         opts.Flip,
+		Flop:         opts.Flop,
+		NoAutoRotate: opts.NoAutoRotate,
+		Type:         bimg.PNG,
+	})
+	if err != nil {
+		return Image{}, err
+	}
+
+	// Preserve the original image type if no output type is defined
+	if opts.Type == bimg.UNKNOWN {
+		opts.Type = bimg.DetermineImageType(buf)
+	}
+
+	opts.Crop = true
+	opts.Gravity = bimg.GravityCentre
+	opts.Rotate = bimg.D0
+	opts.Flip = false
+	opts.Flop = false
+	opts.NoAutoRotate = true
+
+	image, err := Process(extracted.Body, opts)
+	if err != nil {
+		return Image{}, err
+	}
+
+	image.Headers = map[string]string{"X-Crop-Area": area.String()}
+	return image, nil
+}
+
+// findSmartCropArea returns the area with the target aspect ratio that maximizes the luminance
+// entropy of the given image sample, scaled up to the source image dimensions.
+func findSmartCropArea(img image.Image, width, height, targetWidth, targetHeight int) CropArea {
+	area := CropArea{Width: width, Height: height}
+
+	// Largest area with the target aspect ratio fitting in the source image
+	ratio := float64(targetWidth) / float64(targetHeight)
+	if float64(width)/float64(height) > ratio {
+		area.Width = int(math.Min(float64(width), math.Floor(float64(height)*ratio+0.5)))
+	} else {
+		area.Height = int(math.Min(float64(height), math.Floor(float64(width)/ratio+0.5)))
+	}
+
+	bounds := img.Bounds()
+	sampleWidth, sampleHeight := bounds.Dx(), bounds.Dy()
+	if sampleWidth == 0 || sampleHeight == 0 || (area.Width == width && area.Height == height) {
+		return area
+	}
+
+	scaleX := float64(width) / float64(sampleWidth)
+	scaleY := float64(height) / float64(sampleHeight)
+	windowWidth := clamp(int(float64(area.Width)/scaleX+0.5), 1, sampleWidth)
+	windowHeight := clamp(int(float64(area.Height)/scaleY+0.5), 1, sampleHeight)
+
+	luma := make([][]uint8, sampleHeight)
+	for y := 0; y < sampleHeight; y++ {
+		luma[y] = make([]uint8, sampleWidth)
+		for x := 0; x < sampleWidth; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			luma[y][x] = uint8((299*r + 587*g + 114*b) / 1000 >> 8)
+		}
+	}
+
+	// Slide the window along the free axis, updating the histogram incrementally
+	horizontal := windowWidth < sampleWidth
+	histogram := make([]int, 256)
+	for y := 0; y < windowHeight; y++ {
+		for x := 0; x < windowWidth; x++ {
+			histogram[luma[y][x]]++
+		}
+	}
+
+	best, bestEntropy := 0, entropy(histogram, windowWidth*windowHeight)
+	steps := sampleHeight - windowHeight
+	if horizontal {
+		steps = sampleWidth - windowWidth
+	}
+
+	for offset := 1; offset <= steps; offset++ {
+		if horizontal {
+			for y := 0; y < windowHeight; y++ {
+				histogram[luma[y][offset-1]]--
+				histogram[luma[y][offset+windowWidth-1]]++
+			}
+		} else {
+			for x := 0; x < windowWidth; x++ {
+				histogram[luma[offset-1][x]]--
+				histogram[luma[offset+windowHeight-1][x]]++
+			}
+		}
+
+		if value := entropy(histogram, windowWidth*windowHeight); value > bestEntropy {
+			best, bestEntropy = offset, value
+		}
+	}
+
+	if horizontal {
+		area.Left = clamp(int(float64(best)*scaleX+0.5), 0, width-area.Width)
+	} else {
+		area.Top = clamp(int(float64(best)*scaleY+0.5), 0, height-area.Height)
+	}
+
+	return area
+}
+
+// entropy calculates the Shannon entropy of the given histogram
+func entropy(histogram []int, total int) float64 {
+	var value float64
+	for _, count := range histogram {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(total)
+		value -= p * math.Log2(p)
+	}
+	return value
+}
+
+func clamp(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
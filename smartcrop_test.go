@@ -0,0 +1,39 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFindSmartCropArea(t *testing.T) {
+	// Flat image with a noisy region on its right side
+	img := image.NewGray(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 150; x < 200; x++ {
+			img.SetGray(x, y, color.Gray{uint8((x*37 + y*91) % 256)})
+		}
+	}
+
+	area := findSmartCropArea(img, 2000, 1000, 300, 300)
+	if area.Width != 1000 || area.Height != 1000 {
+		t.Fatalf("Invalid area size: %s", area)
+	}
+	if area.Left != 1000 || area.Top != 0 {
+		t.Errorf("Invalid area position: %s", area)
+	}
+
+	area = findSmartCropArea(img, 2000, 1000, 400, 200)
+	if area.String() != "0,0,2000,1000" {
+		t.Errorf("Invalid area: %s", area)
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	if value := entropy([]int{10, 0, 0}, 10); value != 0 {
+		t.Errorf("Invalid entropy: %f", value)
+	}
+	if value := entropy([]int{5, 5}, 10); value != 1 {
+		t.Errorf("Invalid entropy: %f", value)
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 type ImageSourceType string
@@ -15,11 +20,30 @@ type SourceConfig struct {
 	Type            ImageSourceType
 	AllowedOrigings []*url.URL
 	MaxAllowedSize  int
+	EnableS3Source  bool
+	EnableGCSSource bool
+	S3              S3Config
+	GCS             GCSConfig
 }
 
-var imageSourceMap = make(map[ImageSourceType]ImageSource)
+// DefaultSources defines the image sources enabled by default, by matching precedence
+var DefaultSources = []ImageSourceType{
+	ImageSourceTypeFileSystem,
+	ImageSourceTypeHttp,
+	ImageSourceTypeS3,
+	ImageSourceTypeGCS,
+	ImageSourceTypeBody,
+}
+
+// imageSources lists the loaded image sources, by matching precedence
+var imageSources []loadedSource
 var imageSourceFactoryMap = make(map[ImageSourceType]ImageSourceFactoryFunction)
 
+type loadedSource struct {
+	Type   ImageSourceType
+	Source ImageSource
+}
+
 type ImageSource interface {
 	Matches(*http.Request) bool
 	GetImage(*http.Request) ([]byte, error)
@@ -29,24 +53,209 @@ func RegisterSource(sourceType ImageSourceType, factory ImageSourceFactoryFuncti
 	imageSourceFactoryMap[sourceType] = factory
 }
 
+// LoadSources creates the image sources enabled by the server options, keeping their precedence
 func LoadSources(o ServerOptions) {
-	for name, factory := range imageSourceFactoryMap {
-		imageSourceMap[name] = factory(&SourceConfig{
-			Type:            name,
-			MountPath:       o.Mount,
-			AuthForwarding:  o.AuthForwarding,
-			Authorization:   o.Authorization,
-			AllowedOrigings: o.AlloweOrigins,
-			MaxAllowedSize:  o.MaxAllowedSize,
-		})
+	imageSources = nil
+	for _, name := range sourcesPrecedence(o) {
+		factory, ok := imageSourceFactoryMap[name]
+		if !ok || !isSourceEnabled(name, o) {
+			continue
+		}
+
+		imageSources = append(imageSources, loadedSource{name, factory(newSourceConfig(name, o))})
+	}
+}
+
+func newSourceConfig(name ImageSourceType, o ServerOptions) *SourceConfig {
+	return &SourceConfig{
+		Type:            name,
+		MountPath:       o.Mount,
+		AuthForwarding:  o.AuthForwarding,
+		Authorization:   o.Authorization,
+		AllowedOrigings: o.AlloweOrigins,
+		MaxAllowedSize:  o.MaxAllowedSize,
+		EnableS3Source:  o.EnableS3Source,
+		EnableGCSSource: o.EnableGCSSource,
+		S3:              o.S3,
+		GCS:             o.GCS,
+	}
+}
+
+// MatchSource returns the image source matching the request, walking the sources by precedence.
+// Requests matched by several sources, such as defining both file and url params, are rejected as ambiguous.
+func MatchSource(req *http.Request) (ImageSource, error) {
+	var match ImageSource
+	names := []string{}
+
+	for _, loaded := range imageSources {
+		if loaded.Source.Matches(req) {
+			if match == nil {
+				match = loaded.Source
+			}
+			names = append(names, string(loaded.Type))
+		}
+	}
+
+	if match == nil {
+		return nil, ErrMissingImageSource
+	}
+	if len(names) > 1 {
+		return nil, NewError(fmt.Sprintf("Ambiguous image source: the request matches the %s sources", strings.Join(names, ", ")), BadRequest)
+	}
+	return match, nil
+}
+
+// ParseSources parses the comma separated list of image source names, failing if any is not registered
+func ParseSources(value string) ([]ImageSourceType, error) {
+	sources := []ImageSourceType{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := imageSourceFactoryMap[ImageSourceType(name)]; !ok {
+			return nil, fmt.Errorf("unknown image source: %s", name)
+		}
+		sources = append(sources, ImageSourceType(name))
+	}
+	return sources, nil
+}
+
+// sourcesPrecedence returns the image sources allowed by the server options, by matching precedence
+func sourcesPrecedence(o ServerOptions) []ImageSourceType {
+	if o.Sources == nil {
+		return DefaultSources
+	}
+	return o.Sources
+}
+
+// sourceNotEnabledErrors defines the errors replied when reading from a disabled image source
+var sourceNotEnabledErrors = map[ImageSourceType]error{
+	ImageSourceTypeFileSystem: errors.New("local file system image source is not enabled"),
+	ImageSourceTypeHttp:       errors.New("remote URL image source is not enabled"),
+	ImageSourceTypeS3:         errors.New("S3 image source is not enabled"),
+	ImageSourceTypeGCS:        errors.New("Google Cloud Storage image source is not enabled"),
+	ImageSourceTypeBody:       errors.New("payload image source is not enabled"),
+}
+
+// isSourceEnabled reports whether the given image source is allowed by the sources precedence list,
+// and enabled by its own server options, if any, such as the mount directory of the file system source
+func isSourceEnabled(kind ImageSourceType, o ServerOptions) bool {
+	allowed := false
+	for _, name := range sourcesPrecedence(o) {
+		allowed = allowed || name == kind
+	}
+	if !allowed {
+		return false
+	}
+
+	switch kind {
+	case ImageSourceTypeFileSystem:
+		return o.Mount != ""
+	case ImageSourceTypeHttp:
+		return o.EnableURLSource
+	case ImageSourceTypeS3:
+		return o.EnableS3Source
+	case ImageSourceTypeGCS:
+		return o.EnableGCSSource
 	}
+	return true
 }
 
-func MatchSource(req *http.Request) ImageSource {
-	for _, source := range imageSourceMap {
-		if source.Matches(req) {
-			return source
+// acceptsGetRequests reports whether any image source reading images via GET requests,
+// that is, other than the payload one, is enabled
+func acceptsGetRequests(o ServerOptions) bool {
+	for _, kind := range sourcesPrecedence(o) {
+		if kind != ImageSourceTypeBody && isSourceEnabled(kind, o) {
+			return true
 		}
 	}
-	return nil
+	return false
+}
+
+// urlSourceType returns the image source serving the given url param value, based on its scheme
+func urlSourceType(value string) ImageSourceType {
+	if strings.HasPrefix(value, s3URLScheme) {
+		return ImageSourceTypeS3
+	}
+	if strings.HasPrefix(value, gcsURLScheme) {
+		return ImageSourceTypeGCS
+	}
+	return ImageSourceTypeHttp
+}
+
+// readReferenceImage reads a secondary image, such as a watermark, from the mounted directory,
+// a remote URL or an object storage bucket based on the given reference, or from the given multipart form field if no
+// reference is present. Image sources rules, such as the mount path or the allowed origins, are also applied here.
+// Images not supported by bimg, such as JPEG XL, are losslessly decoded.
+func readReferenceImage(r *http.Request, field, ref string, o ServerOptions) ([]byte, error) {
+	if ref == "" {
+		file, _, err := r.FormFile(field)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		buf, err := ioutil.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+		return decodeImage(buf)
+	}
+
+	kind, param := ImageSourceTypeFileSystem, "file"
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") || strings.HasPrefix(ref, s3URLScheme) || strings.HasPrefix(ref, gcsURLScheme) {
+		kind, param = urlSourceType(ref), "url"
+	}
+
+	if !isSourceEnabled(kind, o) {
+		return nil, sourceNotEnabledErrors[kind]
+	}
+
+	buf, err := readImageFromSource(r, kind, param, ref)
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(buf)
+}
+
+func readImageFromSource(r *http.Request, kind ImageSourceType, param, value string) ([]byte, error) {
+	var source ImageSource
+	for _, loaded := range imageSources {
+		if loaded.Type == kind {
+			source = loaded.Source
+		}
+	}
+	if source == nil {
+		return nil, ErrMissingImageSource
+	}
+
+	return source.GetImage(sourceRequest(r, param, value))
+}
+
+// sourceRequest creates the GET request reading the image from the source param, forwarding the request headers
+func sourceRequest(r *http.Request, param, value string) *http.Request {
+	query := url.Values{}
+	query.Set(param, value)
+	return &http.Request{Method: "GET", URL: &url.URL{RawQuery: query.Encode()}, Header: r.Header}
+}
+
+// readLimitedBody reads the response body, failing if it exceeds the maximum allowed size, if any
+func readLimitedBody(body io.Reader, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		return ioutil.ReadAll(body)
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(body, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxSize {
+		return nil, fmt.Errorf("Object size exceeds maximum allowed %d bytes", maxSize)
+	}
+	return buf, nil
+}
+
+func hasImageField(r *http.Request, field string) bool {
+	return r.MultipartForm != nil && len(r.MultipartForm.File[field]) > 0
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ImageSourceTypeGCS ImageSourceType = "gcs"
+
+const (
+	gcsURLScheme       = "gs://"
+	gcsDefaultEndpoint = "https://storage.googleapis.com"
+	gcsDefaultTokenURI = "https://oauth2.googleapis.com/token"
+	gcsReadOnlyScope   = "https://www.googleapis.com/auth/devstorage.read_only"
+)
+
+// GCSConfig defines the Google Cloud Storage endpoint and the service account used to authenticate.
+// Requests are not authenticated if no service account is defined, such as for public buckets or emulators.
+type GCSConfig struct {
+	Endpoint    string
+	Credentials *GCSCredentials
+}
+
+// GCSCredentials defines the fields of the service account JSON key file used to request access tokens
+type GCSCredentials struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	key         *rsa.PrivateKey
+}
+
+type GCSImageSource struct {
+	Config *SourceConfig
+	mutex  sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func NewGCSImageSource(config *SourceConfig) ImageSource {
+	return &GCSImageSource{Config: config}
+}
+
+func (s *GCSImageSource) Matches(r *http.Request) bool {
+	return r.Method == "GET" && s.Config.EnableGCSSource && strings.HasPrefix(r.URL.Query().Get("url"), gcsURLScheme)
+}
+
+func (s *GCSImageSource) GetImage(r *http.Request) ([]byte, error) {
+	bucket, object, err := parseGCSObject(r.URL.Query().Get("url"))
+	if err != nil {
+		return nil, err
+	}
+	return s.fetchObject(bucket, object)
+}
+
+func (s *GCSImageSource) fetchObject(bucket, object string) ([]byte, error) {
+	location := s.objectURL(bucket, object)
+
+	// Check the object size by fetching its metadata
+	if s.Config.MaxAllowedSize > 0 {
+		res, err := s.do(location)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching object metadata: %v", err)
+		}
+		defer res.Body.Close()
+		if err := gcsStatusError(res, bucket, object); err != nil {
+			return nil, err
+		}
+
+		var metadata struct {
+			Size string `json:"size"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&metadata); err != nil {
+			return nil, fmt.Errorf("Invalid object metadata: %v", err)
+		}
+
+		size, _ := strconv.Atoi(metadata.Size)
+		if size > s.Config.MaxAllowedSize {
+			return nil, fmt.Errorf("Object size %d exceeds maximum allowed %d bytes", size, s.Config.MaxAllowedSize)
+		}
+	}
+
+	res, err := s.do(location + "?alt=media")
+	if err != nil {
+		return nil, fmt.Errorf("Error downloading object: %v", err)
+	}
+	defer res.Body.Close()
+	if err := gcsStatusError(res, bucket, object); err != nil {
+		return nil, err
+	}
+
+	return readLimitedBody(res.Body, s.Config.MaxAllowedSize)
+}
+
+// objectURL returns the JSON API URL of the object metadata
+func (s *GCSImageSource) objectURL(bucket, object string) string {
+	endpoint := s.Config.GCS.Endpoint
+	if endpoint == "" {
+		endpoint = gcsDefaultEndpoint
+	}
+	if !strings.Contains(endpoint, "://") {
+		// Emulators are usually defined by host and port, such as STORAGE_EMULATOR_HOST=localhost:4443
+		endpoint = "http://" + endpoint
+	}
+
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", strings.TrimSuffix(endpoint, "/"), gcsEscape(bucket), gcsEscape(object))
+}
+
+func (s *GCSImageSource) do(location string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "imaginary/"+Version)
+
+	if s.Config.GCS.Credentials != nil {
+		token, err := s.accessToken()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+// accessToken returns the cached OAuth 2 access token of the service account, requesting a new one if expired
+func (s *GCSImageSource) accessToken() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != "" && time.Now().Before(s.expiry) {
+		return s.token, nil
+	}
+
+	credentials := s.Config.GCS.Credentials
+	assertion, err := credentials.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	res, err := http.PostForm(credentials.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error requesting access token: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Error requesting access token: (status=%d)", res.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil || token.AccessToken == "" {
+		return "", errors.New("Invalid access token response")
+	}
+
+	// Renew the token a minute before it expires
+	s.token = token.AccessToken
+	s.expiry = time.Now().Add(time.Duration(token.ExpiresIn-60) * time.Second)
+	return s.token, nil
+}
+
+// assertion creates the JWT signed with the service account private key, exchanged for an access token
+func (c *GCSCredentials) assertion(t time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   c.ClientEmail,
+		"scope": gcsReadOnlyScope,
+		"aud":   c.TokenURI,
+		"iat":   t.Unix(),
+		"exp":   t.Add(time.Hour).Unix(),
+	})
+
+	unsigned := base64URLEncode(header) + "." + base64URLEncode(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64URLEncode(signature), nil
+}
+
+// ReadGCSCredentials reads the service account JSON key file, parsing its private key
+func ReadGCSCredentials(path string) (*GCSCredentials, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := &GCSCredentials{}
+	if err := json.Unmarshal(buf, credentials); err != nil {
+		return nil, fmt.Errorf("invalid service account file: %s", err)
+	}
+	if credentials.ClientEmail == "" || credentials.PrivateKey == "" {
+		return nil, errors.New("invalid service account file: missing client_email or private_key")
+	}
+	if credentials.TokenURI == "" {
+		credentials.TokenURI = gcsDefaultTokenURI
+	}
+
+	block, _ := pem.Decode([]byte(credentials.PrivateKey))
+	if block == nil {
+		return nil, errors.New("invalid service account file: cannot decode private_key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if err != nil || !ok {
+		return nil, errors.New("invalid service account file: private_key must be a RSA key")
+	}
+
+	credentials.key = rsaKey
+	return credentials, nil
+}
+
+// parseGCSObject reads the bucket and object name from the gs://bucket/object URL
+func parseGCSObject(location string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(location, gcsURLScheme), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.Trim(parts[1], "/") == "" {
+		return "", "", NewError("Invalid GCS object URL: "+location, BadRequest)
+	}
+	return parts[0], parts[1], nil
+}
+
+func gcsStatusError(res *http.Response, bucket, object string) error {
+	if res.StatusCode == http.StatusNotFound {
+		return NewError(fmt.Sprintf("Object not found: gs://%s/%s", bucket, object), NotFound)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error downloading object: (status=%d) (object=gs://%s/%s)", res.StatusCode, bucket, object)
+	}
+	return nil
+}
+
+// gcsEscape encodes the bucket or object name as a single path segment, including slashes
+func gcsEscape(name string) string {
+	return strings.Replace(url.QueryEscape(name), "+", "%20", -1)
+}
+
+func base64URLEncode(data []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(data), "=")
+}
+
+func init() {
+	RegisterSource(ImageSourceTypeGCS, NewGCSImageSource)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newGCSTestServer creates a local Google Cloud Storage stand-in serving the given object, along with
+// the OAuth 2 token endpoint verifying the service account assertions signed with the given key
+func newGCSTestServer(t *testing.T, key *rsa.PrivateKey, object string, buf []byte) (*httptest.Server, *int) {
+	tokens := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			parts := strings.Split(r.FormValue("assertion"), ".")
+			if len(parts) != 3 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			signature, _ := base64.URLEncoding.DecodeString(parts[2] + strings.Repeat("=", (4-len(parts[2])%4)%4))
+			hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature) != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			tokens++
+			w.Write([]byte(`{"access_token": "foo", "expires_in": 3600, "token_type": "Bearer"}`))
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer foo" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/storage/v1/b/images/o/"+object {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("alt") == "media" {
+			w.Write(buf)
+			return
+		}
+		w.Write([]byte(fmt.Sprintf(`{"bucket": "images", "name": "%s", "size": "%d"}`, object, len(buf))))
+	})), &tokens
+}
+
+func newGCSTestCredentials(t *testing.T) (*GCSCredentials, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	account, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "imaginary@project.iam.gserviceaccount.com",
+		"private_key":  string(block),
+	})
+
+	file, _ := ioutil.TempFile("", "imaginary")
+	defer os.Remove(file.Name())
+	file.Write(account)
+	file.Close()
+
+	credentials, err := ReadGCSCredentials(file.Name())
+	if err != nil {
+		t.Fatalf("Cannot read the service account: %s", err)
+	}
+	return credentials, key
+}
+
+func TestGCSImageSource(t *testing.T) {
+	buf, _ := ioutil.ReadFile(fixtureImage)
+	credentials, key := newGCSTestCredentials(t)
+	ts, tokens := newGCSTestServer(t, key, "path/large.jpg", buf)
+	defer ts.Close()
+	credentials.TokenURI = ts.URL + "/token"
+
+	source := NewGCSImageSource(&SourceConfig{
+		EnableGCSSource: true,
+		MaxAllowedSize:  len(buf),
+		GCS:             GCSConfig{Endpoint: ts.URL, Credentials: credentials},
+	})
+
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("GET", "http://foo/bar?url=gs://images/path/large.jpg", nil)
+		if !source.Matches(r) {
+			t.Fatal("Cannot match the request")
+		}
+
+		body, err := source.GetImage(r)
+		if err != nil {
+			t.Fatalf("Error while reading the body: %s", err)
+		}
+		if len(body) != len(buf) {
+			t.Errorf("Invalid response body length: %d", len(body))
+		}
+	}
+
+	if *tokens != 1 {
+		t.Errorf("The access token must be cached: %d token requests", *tokens)
+	}
+}
+
+func TestGCSImageSourceMatches(t *testing.T) {
+	cases := []struct {
+		query   string
+		enabled bool
+		matches bool
+	}{
+		{"url=gs://images/large.jpg", true, true},
+		{"url=s3://images/large.jpg", true, false},
+		{"url=http://images/large.jpg", true, false},
+		{"url=gs://images/large.jpg", false, false},
+	}
+
+	for _, test := range cases {
+		source := NewGCSImageSource(&SourceConfig{EnableGCSSource: test.enabled})
+		r, _ := http.NewRequest("GET", "http://foo/bar?"+test.query, nil)
+		if source.Matches(r) != test.matches {
+			t.Errorf("Invalid match for %s: expected %t", test.query, test.matches)
+		}
+	}
+}
+
+func TestGCSImageSourceNotFound(t *testing.T) {
+	credentials, key := newGCSTestCredentials(t)
+	ts, _ := newGCSTestServer(t, key, "large.jpg", []byte("foo"))
+	defer ts.Close()
+	credentials.TokenURI = ts.URL + "/token"
+
+	source := NewGCSImageSource(&SourceConfig{EnableGCSSource: true, GCS: GCSConfig{Endpoint: ts.URL, Credentials: credentials}})
+
+	r, _ := http.NewRequest("GET", "http://foo/bar?url=gs://images/missing.jpg", nil)
+	_, err := source.GetImage(r)
+	if xerr, ok := err.(Error); !ok || xerr.HTTPCode() != http.StatusNotFound {
+		t.Fatalf("Invalid error: %#v", err)
+	}
+}
+
+func TestGCSImageSourceExceedsMaximumAllowedLength(t *testing.T) {
+	buf, _ := ioutil.ReadFile(fixture1024Bytes)
+	credentials, key := newGCSTestCredentials(t)
+	ts, _ := newGCSTestServer(t, key, "1024bytes", buf)
+	defer ts.Close()
+	credentials.TokenURI = ts.URL + "/token"
+
+	source := NewGCSImageSource(&SourceConfig{
+		EnableGCSSource: true,
+		MaxAllowedSize:  1023,
+		GCS:             GCSConfig{Endpoint: ts.URL, Credentials: credentials},
+	})
+
+	r, _ := http.NewRequest("GET", "http://foo/bar?url=gs://images/1024bytes", nil)
+	_, err := source.GetImage(r)
+	if err == nil || !strings.Contains(err.Error(), "exceeds maximum allowed") {
+		t.Fatalf("Invalid error: %#v", err)
+	}
+}
+
+func TestGCSImageSourceInvalidCredentials(t *testing.T) {
+	credentials, _ := newGCSTestCredentials(t)
+	_, key := newGCSTestCredentials(t)
+	ts, _ := newGCSTestServer(t, key, "large.jpg", []byte("foo"))
+	defer ts.Close()
+	credentials.TokenURI = ts.URL + "/token"
+
+	source := NewGCSImageSource(&SourceConfig{EnableGCSSource: true, GCS: GCSConfig{Endpoint: ts.URL, Credentials: credentials}})
+
+	r, _ := http.NewRequest("GET", "http://foo/bar?url=gs://images/large.jpg", nil)
+	_, err := source.GetImage(r)
+	if err == nil || !strings.Contains(err.Error(), "access token") {
+		t.Fatalf("Invalid error: %#v", err)
+	}
+}
+
+func TestGCSObjectURL(t *testing.T) {
+	cases := []struct {
+		endpoint string
+		expected string
+	}{
+		{"", "https://storage.googleapis.com/storage/v1/b/images/o/path%2Fmy%20image.jpg"},
+		{"localhost:4443", "http://localhost:4443/storage/v1/b/images/o/path%2Fmy%20image.jpg"},
+		{"http://localhost:4443/", "http://localhost:4443/storage/v1/b/images/o/path%2Fmy%20image.jpg"},
+	}
+
+	for _, test := range cases {
+		source := &GCSImageSource{Config: &SourceConfig{GCS: GCSConfig{Endpoint: test.endpoint}}}
+		if location := source.objectURL("images", "path/my image.jpg"); location != test.expected {
+			t.Errorf("Invalid object URL: %s != %s", location, test.expected)
+		}
+	}
+}
+
+func TestParseGCSObject(t *testing.T) {
+	cases := []struct {
+		location string
+		bucket   string
+		object   string
+		valid    bool
+	}{
+		{"gs://images/path/large.jpg", "images", "path/large.jpg", true},
+		{"gs://images/", "", "", false},
+		{"gs://images", "", "", false},
+		{"gs:///large.jpg", "", "", false},
+	}
+
+	for _, test := range cases {
+		bucket, object, err := parseGCSObject(test.location)
+		if (err == nil) != test.valid {
+			t.Errorf("Invalid error for %s: %v", test.location, err)
+		}
+		if bucket != test.bucket || object != test.object {
+			t.Errorf("Invalid object for %s: %s, %s", test.location, bucket, object)
+		}
+	}
+}
+
+func TestReadGCSCredentialsInvalidFile(t *testing.T) {
+	file, _ := ioutil.TempFile("", "imaginary")
+	defer os.Remove(file.Name())
+	file.Write([]byte(`{"client_email": "foo@bar", "private_key": "foo"}`))
+	file.Close()
+
+	if _, err := ReadGCSCredentials(file.Name()); err == nil {
+		t.Fatal("Invalid service account must fail")
+	}
+}
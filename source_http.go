@@ -19,7 +19,8 @@ func NewHttpImageSource(config *SourceConfig) ImageSource {
 }
 
 func (s *HttpImageSource) Matches(r *http.Request) bool {
-	return r.Method == "GET" && r.URL.Query().Get("url") != ""
+	url := r.URL.Query().Get("url")
+	return r.Method == "GET" && url != "" && urlSourceType(url) == ImageSourceTypeHttp
 }
 
 func (s *HttpImageSource) GetImage(req *http.Request) ([]byte, error) {
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ImageSourceTypeS3 ImageSourceType = "s3"
+
+const (
+	s3URLScheme     = "s3://"
+	s3DefaultRegion = "us-east-1"
+	// s3EmptyPayload is the SHA256 hash of the empty body of GET and HEAD requests
+	s3EmptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Config defines the S3-compatible object storage endpoint and credentials.
+// Requests are not signed if no credentials are defined, such as for public buckets.
+type S3Config struct {
+	Endpoint     string
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+type S3ImageSource struct {
+	Config *SourceConfig
+}
+
+func NewS3ImageSource(config *SourceConfig) ImageSource {
+	return &S3ImageSource{config}
+}
+
+func (s *S3ImageSource) Matches(r *http.Request) bool {
+	query := r.URL.Query()
+	return r.Method == "GET" && s.Config.EnableS3Source &&
+		(query.Get("bucket") != "" || strings.HasPrefix(query.Get("url"), s3URLScheme))
+}
+
+func (s *S3ImageSource) GetImage(r *http.Request) ([]byte, error) {
+	bucket, key, err := parseS3Object(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	return s.fetchObject(bucket, key)
+}
+
+func (s *S3ImageSource) fetchObject(bucket, key string) ([]byte, error) {
+	object := s.objectURL(bucket, key)
+
+	// Check the object size by fetching its HTTP headers
+	if s.Config.MaxAllowedSize > 0 {
+		res, err := s.do("HEAD", object)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching object headers: %v", err)
+		}
+		res.Body.Close()
+		if err := s3StatusError(res, bucket, key); err != nil {
+			return nil, err
+		}
+
+		contentLength, _ := strconv.Atoi(res.Header.Get("Content-Length"))
+		if contentLength > s.Config.MaxAllowedSize {
+			return nil, fmt.Errorf("Content-Length %d exceeds maximum allowed %d bytes", contentLength, s.Config.MaxAllowedSize)
+		}
+	}
+
+	res, err := s.do("GET", object)
+	if err != nil {
+		return nil, fmt.Errorf("Error downloading object: %v", err)
+	}
+	defer res.Body.Close()
+	if err := s3StatusError(res, bucket, key); err != nil {
+		return nil, err
+	}
+
+	return readLimitedBody(res.Body, s.Config.MaxAllowedSize)
+}
+
+// objectURL returns the object URL, using virtual-hosted style for AWS and path style for custom endpoints
+func (s *S3ImageSource) objectURL(bucket, key string) *url.URL {
+	path := "/" + strings.TrimPrefix(key, "/")
+
+	if s.Config.S3.Endpoint == "" {
+		host := fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, s.region())
+		return &url.URL{Scheme: "https", Host: host, Path: path}
+	}
+
+	endpoint, _ := url.Parse(strings.TrimSuffix(s.Config.S3.Endpoint, "/"))
+	return &url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host, Path: endpoint.Path + "/" + bucket + path}
+}
+
+func (s *S3ImageSource) region() string {
+	if s.Config.S3.Region == "" {
+		return s3DefaultRegion
+	}
+	return s.Config.S3.Region
+}
+
+func (s *S3ImageSource) do(method string, object *url.URL) (*http.Response, error) {
+	req, _ := http.NewRequest(method, object.String(), nil)
+	req.URL = object
+	req.Header.Set("User-Agent", "imaginary/"+Version)
+
+	if s.Config.S3.AccessKey != "" {
+		signS3Request(req, s.Config.S3, s.region(), time.Now())
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+// parseS3Object reads the bucket and object key from the bucket and object params,
+// or from the s3://bucket/key URL defined in the url param
+func parseS3Object(query url.Values) (string, string, error) {
+	bucket, key := query.Get("bucket"), query.Get("object")
+
+	if bucket == "" {
+		location := strings.TrimPrefix(query.Get("url"), s3URLScheme)
+		parts := strings.SplitN(location, "/", 2)
+		if len(parts) == 2 {
+			bucket, key = parts[0], parts[1]
+		}
+	}
+
+	if bucket == "" || strings.Trim(key, "/") == "" {
+		return "", "", NewError("Missing required params: bucket and object", BadRequest)
+	}
+	return bucket, key, nil
+}
+
+func s3StatusError(res *http.Response, bucket, key string) error {
+	if res.StatusCode == http.StatusNotFound {
+		return NewError(fmt.Sprintf("Object not found: s3://%s/%s", bucket, key), NotFound)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error downloading object: (status=%d) (object=s3://%s/%s)", res.StatusCode, bucket, key)
+	}
+	return nil
+}
+
+// signS3Request signs the request using AWS Signature Version 4, signing the host and every request header
+func signS3Request(req *http.Request, config S3Config, region string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	scope := date + "/" + region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3EmptyPayload)
+	if config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", config.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name == "User-Agent" {
+			continue
+		}
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		s3EmptyPayload,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(sha256Sum([]byte(canonicalRequest))),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+config.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		config.AccessKey, scope, signedHeaders, signature))
+}
+
+// s3EscapePath encodes the path as required by AWS Signature Version 4, escaping every byte but the unreserved characters and slashes
+func s3EscapePath(path string) string {
+	escaped := ""
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || strings.IndexByte("-_.~/", c) >= 0 {
+			escaped += string(c)
+		} else {
+			escaped += fmt.Sprintf("%%%02X", c)
+		}
+	}
+	return escaped
+}
+
+func sha256Sum(data []byte) []byte {
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func init() {
+	RegisterSource(ImageSourceTypeS3, NewS3ImageSource)
+}
@@ -3,15 +3,80 @@ package main
 import (
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 )
 
 func TestMatchSource(t *testing.T) {
+	LoadSources(ServerOptions{EnableURLSource: true})
+
 	u, _ := url.Parse("http://foo?url=http://bar/image.jpg")
 	req := &http.Request{Method: "GET", URL: u}
 
-	source := MatchSource(req)
-	if source == nil {
+	source, err := MatchSource(req)
+	if err != nil || source == nil {
 		t.Error("Cannot match image source")
 	}
 }
+
+func TestMatchSourceAmbiguous(t *testing.T) {
+	LoadSources(ServerOptions{Mount: "fixtures", EnableURLSource: true})
+
+	u, _ := url.Parse("http://foo?file=large.jpg&url=http://bar/image.jpg")
+	req := &http.Request{Method: "GET", URL: u}
+
+	_, err := MatchSource(req)
+	if err == nil || !strings.Contains(err.Error(), "matches the fs, http sources") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestMatchSourceDisabled(t *testing.T) {
+	cases := []struct {
+		opts    ServerOptions
+		query   string
+		matches bool
+	}{
+		{ServerOptions{EnableURLSource: true}, "file=large.jpg", false},
+		{ServerOptions{Mount: "fixtures", EnableURLSource: true}, "file=large.jpg&url=http://bar/image.jpg", false},
+		{ServerOptions{Mount: "fixtures", EnableURLSource: true, Sources: []ImageSourceType{ImageSourceTypeHttp}}, "file=large.jpg&url=http://bar/image.jpg", true},
+		{ServerOptions{Mount: "fixtures", Sources: []ImageSourceType{ImageSourceTypeHttp}}, "file=large.jpg", false},
+	}
+
+	for _, test := range cases {
+		LoadSources(test.opts)
+		u, _ := url.Parse("http://foo?" + test.query)
+		source, err := MatchSource(&http.Request{Method: "GET", URL: u})
+		if (err == nil && source != nil) != test.matches {
+			t.Errorf("Invalid match for %s: %v", test.query, err)
+		}
+	}
+}
+
+func TestMatchSourcePrecedence(t *testing.T) {
+	LoadSources(ServerOptions{Mount: "fixtures", EnableURLSource: true, Sources: []ImageSourceType{ImageSourceTypeHttp, ImageSourceTypeFileSystem}})
+
+	if len(imageSources) != 2 || imageSources[0].Type != ImageSourceTypeHttp || imageSources[1].Type != ImageSourceTypeFileSystem {
+		t.Fatalf("Invalid image sources: %#v", imageSources)
+	}
+
+	u, _ := url.Parse("http://foo?file=large.jpg&url=http://bar/image.jpg")
+	_, err := MatchSource(&http.Request{Method: "GET", URL: u})
+	if err == nil || !strings.Contains(err.Error(), "matches the http, fs sources") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestParseSources(t *testing.T) {
+	sources, err := ParseSources("fs, http,payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 3 || sources[0] != ImageSourceTypeFileSystem || sources[2] != ImageSourceTypeBody {
+		t.Errorf("Invalid sources: %#v", sources)
+	}
+
+	if _, err := ParseSources("fs,ftp"); err == nil || !strings.Contains(err.Error(), "ftp") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
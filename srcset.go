@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+)
+
+const srcsetMaxVariants = 24
+
+// SrcsetVariant represents a single resized image variant of a srcset
+type SrcsetVariant struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Type   string `json:"type"`
+	Mime   string `json:"mime"`
+	Size   int    `json:"size"`
+	Body   []byte `json:"-"`
+}
+
+// Name returns the variant file name, such as 320w.webp
+func (v SrcsetVariant) Name() string {
+	return fmt.Sprintf("%dw.%s", v.Width, v.Type)
+}
+
+// srcsetWidths removes the duplicated widths and the ones larger than the image width,
+// since images are never enlarged. The image width is used if none of them fits.
+func srcsetWidths(widths []int, max int) []int {
+	seen := make(map[int]bool)
+	list := []int{}
+	for _, width := range widths {
+		if width > 0 && width <= max && !seen[width] {
+			seen[width] = true
+			list = append(list, width)
+		}
+	}
+	if len(list) == 0 {
+		list = append(list, max)
+	}
+	return list
+}
+
+// EncodeSrcset encodes the image variants in the given output format:
+// json (default), multipart or zip. It returns the encoded body and its MIME type.
+func EncodeSrcset(variants []SrcsetVariant, output string) ([]byte, string, error) {
+	switch output {
+	case "", "json":
+		return encodeSrcsetJSON(variants)
+	case "multipart":
+		return encodeSrcsetMultipart(variants)
+	case "zip":
+		return encodeSrcsetZip(variants)
+	}
+	return nil, "", fmt.Errorf("unsupported output: %s", output)
+}
+
+func encodeSrcsetJSON(variants []SrcsetVariant) ([]byte, string, error) {
+	type variant struct {
+		SrcsetVariant
+		Name string `json:"name"`
+		Body string `json:"body"`
+	}
+
+	manifest := struct {
+		Variants []variant `json:"variants"`
+	}{Variants: []variant{}}
+
+	for _, v := range variants {
+		manifest.Variants = append(manifest.Variants, variant{v, v.Name(), base64.StdEncoding.EncodeToString(v.Body)})
+	}
+
+	body, err := json.Marshal(manifest)
+	return body, "application/json", err
+}
+
+func encodeSrcsetMultipart(variants []SrcsetVariant) ([]byte, string, error) {
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+
+	for _, v := range variants {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", v.Mime)
+		header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, v.Name()))
+		header.Set("Image-Width", strconv.Itoa(v.Width))
+		header.Set("Image-Height", strconv.Itoa(v.Height))
+
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(v.Body); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "multipart/mixed; boundary=" + writer.Boundary(), nil
+}
+
+func encodeSrcsetZip(variants []SrcsetVariant) ([]byte, string, error) {
+	buf := &bytes.Buffer{}
+	writer := zip.NewWriter(buf)
+
+	for _, v := range variants {
+		// Images are already compressed, so just store them
+		file, err := writer.CreateHeader(&zip.FileHeader{Name: v.Name(), Method: zip.Store})
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := file.Write(v.Body); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "application/zip", nil
+}
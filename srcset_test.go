@@ -0,0 +1,112 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"reflect"
+	"testing"
+)
+
+var testVariants = []SrcsetVariant{
+	{Width: 320, Height: 180, Type: "jpeg", Mime: "image/jpeg", Size: 3, Body: []byte("foo")},
+	{Width: 640, Height: 360, Type: "webp", Mime: "image/webp", Size: 3, Body: []byte("bar")},
+}
+
+func TestSrcsetWidths(t *testing.T) {
+	cases := []struct {
+		widths   []int
+		max      int
+		expected []int
+	}{
+		{[]int{320, 640, 320, 1024}, 800, []int{320, 640}},
+		{[]int{0, 2048}, 800, []int{800}},
+	}
+
+	for _, test := range cases {
+		if widths := srcsetWidths(test.widths, test.max); !reflect.DeepEqual(widths, test.expected) {
+			t.Errorf("Invalid widths: %#v != %#v", widths, test.expected)
+		}
+	}
+}
+
+func TestEncodeSrcsetJSON(t *testing.T) {
+	body, mimeType, err := EncodeSrcset(testVariants, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mimeType != "application/json" {
+		t.Fatalf("Invalid content type: %s", mimeType)
+	}
+
+	manifest := struct {
+		Variants []struct {
+			Name  string
+			Width int
+			Body  []byte
+		}
+	}{}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Variants) != 2 || manifest.Variants[1].Name != "640w.webp" || string(manifest.Variants[1].Body) != "bar" {
+		t.Errorf("Invalid manifest: %#v", manifest)
+	}
+}
+
+func TestEncodeSrcsetMultipart(t *testing.T) {
+	body, mimeType, err := EncodeSrcset(testVariants, "multipart")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Invalid content type: %s", mimeType)
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for _, variant := range testVariants {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(part)
+		if part.FileName() != variant.Name() || part.Header.Get("Content-Type") != variant.Mime || string(data) != string(variant.Body) {
+			t.Errorf("Invalid part: %s", part.FileName())
+		}
+	}
+}
+
+func TestEncodeSrcsetZip(t *testing.T) {
+	body, mimeType, err := EncodeSrcset(testVariants, "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mimeType != "application/zip" {
+		t.Fatalf("Invalid content type: %s", mimeType)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.File) != 2 || reader.File[0].Name != "320w.jpeg" {
+		t.Fatalf("Invalid zip files: %d", len(reader.File))
+	}
+
+	file, _ := reader.File[0].Open()
+	data, _ := ioutil.ReadAll(file)
+	if string(data) != "foo" {
+		t.Errorf("Invalid zip file content: %s", data)
+	}
+}
+
+func TestEncodeSrcsetInvalidOutput(t *testing.T) {
+	if _, _, err := EncodeSrcset(testVariants, "tar"); err == nil {
+		t.Error("Unsupported output must fail")
+	}
+}
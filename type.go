@@ -1,11 +1,53 @@
 package main
 
 import (
+	"bytes"
+	"strconv"
 	"strings"
 
 	"gopkg.in/h2non/bimg.v1"
 )
 
+// ImageTypes lists the image format names supported by imaginary, if libvips supports them too.
+var ImageTypes = []string{"jpeg", "png", "webp", "tiff", "gif", "svg", "pdf", "heif", "avif", "jxl"}
+
+// JXL is the JPEG XL image type. Since bimg v1 doesn't support it, JPEG XL
+// images are decoded and encoded via libvips.
+const JXL bimg.ImageType = 100
+
+var (
+	// jxlCodestream and jxlContainer are the signatures of JPEG XL images
+	jxlCodestream = []byte{0xFF, 0x0A}
+	jxlContainer  = []byte{0x00, 0x00, 0x00, 0x0C, 0x4A, 0x58, 0x4C, 0x20, 0x0D, 0x0A, 0x87, 0x0A}
+)
+
+// SupportedImageTypes stores the load and save support of each image format detected from libvips.
+var SupportedImageTypes = map[string]bimg.SupportedImageType{}
+
+// DetectSupportedImageTypes detects the image formats supported by the libvips installation.
+func DetectSupportedImageTypes() {
+	for _, name := range ImageTypes {
+		kind := ImageType(name)
+		if kind == JXL {
+			SupportedImageTypes[name] = bimg.SupportedImageType{
+				Load: vipsHasOperation("jxlload_buffer"),
+				Save: vipsHasOperation("jxlsave_buffer"),
+			}
+			continue
+		}
+		if kind == bimg.UNKNOWN {
+			SupportedImageTypes[name] = bimg.SupportedImageType{}
+			continue
+		}
+		SupportedImageTypes[name] = bimg.IsImageTypeSupportedByVips(kind)
+	}
+}
+
+// IsImageTypeSupportedSave returns true if the given output image type name can be encoded.
+func IsImageTypeSupportedSave(name string) bool {
+	return SupportedImageTypes[imageTypeAlias(name)].Save
+}
+
 // ExtractImageTypeFromMime returns the MIME image type.
 func ExtractImageTypeFromMime(mime string) string {
 	mime = strings.Split(mime, ";")[0]
@@ -27,12 +69,24 @@ func IsImageMimeTypeSupported(mime string) bool {
 		format = "svg"
 	}
 
-	return bimg.IsTypeNameSupported(format)
+	return SupportedImageTypes[imageTypeAlias(format)].Load
+}
+
+// imageTypeAlias normalizes the alternative names of an image type.
+func imageTypeAlias(name string) string {
+	name = strings.ToLower(name)
+	if name == "jpg" {
+		return "jpeg"
+	}
+	if name == "heic" {
+		return "heif"
+	}
+	return name
 }
 
 // ImageType returns the image type based on the given image type alias.
 func ImageType(name string) bimg.ImageType {
-	ext := strings.ToLower(name)
+	ext := imageTypeAlias(name)
 	if ext == "jpeg" {
 		return bimg.JPEG
 	}
@@ -54,11 +108,49 @@ func ImageType(name string) bimg.ImageType {
 	if ext == "pdf" {
 		return bimg.PDF
 	}
+	if ext == "heif" {
+		return bimg.HEIF
+	}
+	if ext == "avif" {
+		return bimg.AVIF
+	}
+	if ext == "jxl" {
+		return JXL
+	}
 	return bimg.UNKNOWN
 }
 
+// ImageTypeName returns the name of the given image type, including JPEG XL.
+func ImageTypeName(kind bimg.ImageType) string {
+	if kind == JXL {
+		return "jxl"
+	}
+	return bimg.ImageTypeName(kind)
+}
+
+// DetermineImageType determines the image type of the buffer, including JPEG XL.
+func DetermineImageType(buf []byte) bimg.ImageType {
+	if bytes.HasPrefix(buf, jxlCodestream) || bytes.HasPrefix(buf, jxlContainer) {
+		return JXL
+	}
+	return bimg.DetermineImageType(buf)
+}
+
+// decodeImage losslessly decodes the images not supported by bimg, such as JPEG XL, to PNG via libvips.
+// The rest of images are returned as is.
+func decodeImage(buf []byte) ([]byte, error) {
+	if DetermineImageType(buf) != JXL {
+		return buf, nil
+	}
+	return vipsLoadBuffer(buf, "")
+}
+
 // GetImageMimeType returns the MIME type based on the given image type code.
+// An empty string is returned if the image type is unknown.
 func GetImageMimeType(code bimg.ImageType) string {
+	if code == bimg.JPEG {
+		return "image/jpeg"
+	}
 	if code == bimg.PNG {
 		return "image/png"
 	}
@@ -77,8 +169,61 @@ func GetImageMimeType(code bimg.ImageType) string {
 	if code == bimg.PDF {
 		return "application/pdf"
 	}
-	if code == bimg.TIFF {
-		return "image/tiff"
+	if code == bimg.HEIF {
+		return "image/heif"
+	}
+	if code == bimg.AVIF {
+		return "image/avif"
+	}
+	if code == JXL {
+		return "image/jxl"
 	}
-	return "image/jpeg"
+	return ""
+}
+
+// AutoImageType returns the best output image type name accepted by the client based on the given
+// Accept header value, by order of preference: AVIF, WEBP and the original image type.
+func AutoImageType(accept string, buf []byte) string {
+	for _, name := range []string{"avif", "webp"} {
+		if IsImageTypeSupportedSave(name) && isMimeTypeAccepted(accept, "image/"+name) {
+			return name
+		}
+	}
+
+	if name := bimg.DetermineImageTypeName(buf); IsImageTypeSupportedSave(name) {
+		return name
+	}
+
+	// Original image cannot be encoded, such as SVG or PDF, so preserve transparency if present
+	meta, err := bimg.Metadata(buf)
+	if err == nil && meta.Alpha {
+		return "png"
+	}
+	return "jpeg"
+}
+
+// isMimeTypeAccepted returns true if the given MIME type is explicitly accepted
+// in the Accept header value. Wildcards are ignored on purpose, since most clients
+// use them regardless of the image formats they actually support.
+func isMimeTypeAccepted(accept, mime string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		if strings.ToLower(strings.TrimSpace(params[0])) != mime {
+			continue
+		}
+
+		for _, param := range params[1:] {
+			param = strings.Replace(param, " ", "", -1)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func init() {
+	DetectSupportedImageTypes()
 }
@@ -42,6 +42,9 @@ func TestIsImageTypeSupported(t *testing.T) {
 		{"image/svg", bimg.IsImageTypeSupportedByVips(bimg.SVG).Load},
 		{"image/tiff", bimg.IsImageTypeSupportedByVips(bimg.TIFF).Load},
 		{"application/pdf", bimg.IsImageTypeSupportedByVips(bimg.PDF).Load},
+		{"image/heif", bimg.IsImageTypeSupportedByVips(bimg.HEIF).Load},
+		{"image/heic", bimg.IsImageTypeSupportedByVips(bimg.HEIF).Load},
+		{"image/avif", bimg.IsImageTypeSupportedByVips(bimg.AVIF).Load},
 		{"text/plain", false},
 		{"blablabla", false},
 		{"", false},
@@ -66,6 +69,11 @@ func TestImageType(t *testing.T) {
 		{"gif", bimg.GIF},
 		{"svg", bimg.SVG},
 		{"pdf", bimg.PDF},
+		{"heif", bimg.HEIF},
+		{"HEIC", bimg.HEIF},
+		{"avif", bimg.AVIF},
+		{"jpg", bimg.JPEG},
+		{"jxl", JXL},
 		{"multipart/form-data; encoding=utf-8", bimg.UNKNOWN},
 		{"json", bimg.UNKNOWN},
 		{"text", bimg.UNKNOWN},
@@ -92,7 +100,10 @@ func TestGetImageMimeType(t *testing.T) {
 		{bimg.GIF, "image/gif"},
 		{bimg.PDF, "application/pdf"},
 		{bimg.SVG, "image/svg+xml"},
-		{bimg.UNKNOWN, "image/jpeg"},
+		{bimg.HEIF, "image/heif"},
+		{bimg.AVIF, "image/avif"},
+		{JXL, "image/jxl"},
+		{bimg.UNKNOWN, ""},
 	}
 
 	for _, file := range files {
@@ -101,3 +112,61 @@ func TestGetImageMimeType(t *testing.T) {
 		}
 	}
 }
+
+func TestDetermineImageTypeJXL(t *testing.T) {
+	codestream := []byte{0xFF, 0x0A, 0xFA, 0x7F}
+	container := []byte{0x00, 0x00, 0x00, 0x0C, 0x4A, 0x58, 0x4C, 0x20, 0x0D, 0x0A, 0x87, 0x0A, 0x00}
+
+	for _, buf := range [][]byte{codestream, container} {
+		if kind := DetermineImageType(buf); kind != JXL {
+			t.Errorf("Invalid image type: %d", kind)
+		}
+	}
+	if ImageTypeName(JXL) != "jxl" {
+		t.Errorf("Invalid image type name: %s", ImageTypeName(JXL))
+	}
+}
+
+func TestIsImageTypeSupportedSave(t *testing.T) {
+	files := []struct {
+		name     string
+		expected bool
+	}{
+		{"jpeg", bimg.IsImageTypeSupportedByVips(bimg.JPEG).Save},
+		{"png", bimg.IsImageTypeSupportedByVips(bimg.PNG).Save},
+		{"avif", bimg.IsImageTypeSupportedByVips(bimg.AVIF).Save},
+		{"heic", bimg.IsImageTypeSupportedByVips(bimg.HEIF).Save},
+		{"jxl", vipsHasOperation("jxlsave_buffer")},
+		{"bmp", false},
+		{"", false},
+	}
+
+	for _, file := range files {
+		if IsImageTypeSupportedSave(file.name) != file.expected {
+			t.Fatalf("Invalid type: %s != %t", file.name, file.expected)
+		}
+	}
+}
+
+func TestIsMimeTypeAccepted(t *testing.T) {
+	cases := []struct {
+		accept   string
+		mime     string
+		expected bool
+	}{
+		{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", "image/avif", true},
+		{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", "image/webp", true},
+		{"image/webp;q=0.9, image/png", "image/webp", true},
+		{"IMAGE/WEBP", "image/webp", true},
+		{"image/webp;q=0", "image/webp", false},
+		{"image/*,*/*;q=0.8", "image/avif", false},
+		{"image/png", "image/webp", false},
+		{"", "image/webp", false},
+	}
+
+	for _, test := range cases {
+		if isMimeTypeAccepted(test.accept, test.mime) != test.expected {
+			t.Errorf("Invalid accept negotiation: %s in %s != %t", test.mime, test.accept, test.expected)
+		}
+	}
+}
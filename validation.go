@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// sourceParams are the params used to read the image and the request, allowed in every operation.
+// The S3 object key is defined via the object param, since the key param defines the API key.
+var sourceParams = []string{"file", "url", "bucket", "object", "field", "key", "page", "n", "density", "dpr"}
+
+// outputParams are the params used to encode the output image
+var outputParams = []string{
+	"type", "quality", "maxbytes", "compression", "effort", "noprofile", "colorspace",
+	"progressive", "lossless", "nearlossless", "palette", "palettecolors", "subsampling", "strip",
+}
+
+// paramsWithoutKind are the known params which are not image options, such as the sheet captions
+var paramsWithoutKind = []string{"caption"}
+
+// transformParams are the params shared by the image transformation operations
+var transformParams = []string{
+	"width", "height", "aspect", "embed", "force", "nocrop", "rotate", "norotation",
+	"flip", "flop", "extend", "background", "gravity",
+}
+
+// OperationParams defines the params of an operation. Each required group
+// must have at least one of its params defined.
+type OperationParams struct {
+	Required [][]string
+	Allowed  []string
+}
+
+// operationParams defines the params of each operation, by its endpoint name
+var operationParams = map[string]OperationParams{
+	"info":    {Allowed: []string{"metadata", "norotation"}},
+	"palette": {Allowed: []string{"colors", "norotation"}},
+	"lqip": {
+		Allowed: []string{"kind", "xcomponents", "ycomponents", "width", "height", "quality", "type", "sigma", "norotation"},
+	},
+	"hash": {Allowed: []string{"compare", "algorithm", "threshold", "norotation"}},
+	"srcset": {
+		Required: [][]string{{"widths"}},
+		Allowed:  withParams([]string{"widths", "types", "output", "rotate", "norotation", "flip", "flop"}, outputParams),
+	},
+	"sheet": {
+		Allowed: []string{
+			"width", "height", "columns", "gap", "background", "caption", "font", "color", "dpi",
+			"output", "type", "quality", "compression", "norotation",
+		},
+	},
+	"resize": {
+		Required: [][]string{{"width", "height", "aspect"}},
+		Allowed:  withParams(transformParams, outputParams),
+	},
+	"enlarge": {
+		Required: [][]string{{"width", "aspect"}, {"height", "aspect"}},
+		Allowed:  withParams(transformParams, outputParams),
+	},
+	"extract": {
+		Required: [][]string{{"areawidth"}, {"areaheight", "aspect"}},
+		Allowed:  withParams([]string{"top", "left", "areawidth", "areaheight"}, transformParams, outputParams),
+	},
+	"crop": {
+		Required: [][]string{{"width", "height", "aspect"}},
+		Allowed:  withParams(transformParams, outputParams),
+	},
+	"rotate": {
+		Required: [][]string{{"rotate"}},
+		Allowed:  withParams([]string{"inscribe"}, transformParams, outputParams),
+	},
+	"flip":      {Allowed: withParams(transformParams, outputParams)},
+	"flop":      {Allowed: withParams(transformParams, outputParams)},
+	"thumbnail": {Required: [][]string{{"width", "height", "aspect"}}, Allowed: withParams(transformParams, outputParams)},
+	"zoom": {
+		Required: [][]string{{"factor"}},
+		Allowed:  withParams([]string{"factor", "top", "left", "areawidth", "areaheight"}, transformParams, outputParams),
+	},
+	"convert": {
+		Required: [][]string{{"type"}},
+		Allowed:  withParams(transformParams, outputParams),
+	},
+	"watermark": {
+		Required: [][]string{{"text"}},
+		Allowed: withParams([]string{
+			"text", "margin", "dpi", "textwidth", "opacity", "noreplicate", "font", "color",
+		}, transformParams, outputParams),
+	},
+	"watermarkimage": {
+		Allowed: withParams([]string{"image", "top", "left", "margin", "opacity", "scale", "tile"}, transformParams, outputParams),
+	},
+	"blur": {
+		Required: [][]string{{"sigma", "minampl"}},
+		Allowed:  withParams([]string{"sigma", "minampl"}, transformParams, outputParams),
+	},
+	"sharpen": {
+		Required: [][]string{{"radius"}},
+		Allowed:  withParams([]string{"radius", "flat", "jagged"}, transformParams, outputParams),
+	},
+	"pipeline": {
+		Required: [][]string{{"operations"}},
+		// The output params apply to the output image, except the colorspace, which only applies to each step
+		Allowed: []string{
+			"operations", "image", "type", "quality", "maxbytes", "compression", "effort", "noprofile",
+			"progressive", "lossless", "nearlossless", "palette", "palettecolors", "subsampling", "strip",
+		},
+	},
+}
+
+// paramRange defines the allowed numeric range of a param
+type paramRange struct {
+	Min float64
+	Max float64
+}
+
+const unbounded = math.MaxInt32
+
+var paramRanges = map[string]paramRange{
+	"width":       {1, unbounded},
+	"height":      {1, unbounded},
+	"quality":     {1, 100},
+	"compression": {0, 9},
+	"maxbytes":    {1, unbounded},
+	"effort":      {1, 9},
+	"top":         {0, unbounded},
+	"left":        {0, unbounded},
+	"areawidth":   {1, unbounded},
+	"areaheight":  {1, unbounded},
+	"rotate":      {-360, 360},
+	"margin":      {0, unbounded},
+	"factor":      {1, unbounded},
+	"dpi":         {1, 2400},
+	"textwidth":   {1, unbounded},
+	"radius":      {1, unbounded},
+	"colors":      {1, paletteMaxColors},
+	"xcomponents": {1, 9},
+	"ycomponents": {1, 9},
+	"threshold":   {0, 64},
+	"columns":     {1, sheetMaxTiles},
+	"gap":         {0, sheetMaxGap},
+	"page":        {0, unbounded},
+	"n":           {1, unbounded},
+	"opacity":     {0, 1},
+	"sigma":       {0, unbounded},
+	"minampl":     {0, unbounded},
+	"flat":        {0, unbounded},
+	"jagged":      {0, unbounded},
+	"scale":       {0, 1},
+	"density":     {1, 2400},
+	"dpr":         {1, maxDPR},
+	"widths":      {1, unbounded},
+
+	// Encoder params
+	"palettecolors": {1, 256},
+}
+
+// paramEnums defines the allowed values of the params with a fixed set of values
+var paramEnums = map[string][]string{
+	"type":        append([]string{"auto", "jpg", "heic"}, ImageTypes...),
+	"types":       append([]string{"jpg", "heic"}, ImageTypes...),
+	"gravity":     {"centre", "north", "south", "east", "west", "smart"},
+	"extend":      {"black", "white", "copy", "mirror", "background"},
+	"colorspace":  {"srgb", "bw"},
+	"kind":        {"blurhash", "thumbhash", "datauri"},
+	"algorithm":   {"ahash", "dhash", "phash"},
+	"output":      {"json", "multipart", "zip"},
+	"metadata":    {"full"},
+	"subsampling": {"auto", "420", "444"},
+}
+
+func withParams(groups ...[]string) []string {
+	params := []string{}
+	for _, group := range groups {
+		params = append(params, group...)
+	}
+	return params
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateParams strictly validates the given params of an operation, returning an error per
+// unknown, invalid or out of range param, and per missing required param. The operation specific
+// rules are skipped for unknown operations.
+func ValidateParams(query url.Values, operation string) []ParamError {
+	_, paramErrors := readParams(query)
+	return validateOperationParams(query, operation, paramErrors)
+}
+
+// validateOperationParams validates the params of an operation, merging the given errors of the
+// invalid param values, as returned by readParams, in the params order.
+func validateOperationParams(query url.Values, operation string, paramErrors []ParamError) []ParamError {
+	validationErrors := []ParamError{}
+	rules, hasRules := operationParams[operation]
+
+	invalid := map[string]ParamError{}
+	for _, err := range paramErrors {
+		invalid[err.Param] = err
+	}
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := query.Get(key)
+		_, known := allowedParams[key]
+
+		if !known && !containsString(sourceParams, key) && !containsString(paramsWithoutKind, key) {
+			validationErrors = append(validationErrors, ParamError{Param: key, Value: value, Message: "unknown param"})
+			continue
+		}
+		if hasRules && !containsString(sourceParams, key) && !containsString(rules.Allowed, key) {
+			validationErrors = append(validationErrors, ParamError{Param: key, Value: value, Message: fmt.Sprintf("param not allowed in %s operation", operation)})
+			continue
+		}
+		if err, ok := invalid[key]; ok {
+			validationErrors = append(validationErrors, err)
+		}
+	}
+
+	if hasRules {
+		for _, group := range rules.Required {
+			if !hasAnyParam(query, group) {
+				validationErrors = append(validationErrors, ParamError{Param: strings.Join(group, " or "), Message: "missing required param"})
+			}
+		}
+	}
+
+	if operation == "pipeline" && query.Get("operations") != "" {
+		validationErrors = append(validationErrors, validatePipelineParams(query.Get("operations"))...)
+	}
+
+	return validationErrors
+}
+
+func hasAnyParam(query url.Values, params []string) bool {
+	for _, param := range params {
+		if query.Get(param) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePipelineParams validates the params of each pipeline step against its operation rules
+func validatePipelineParams(data string) []ParamError {
+	operations, err := parseJSONOperations(data)
+	if err != nil {
+		// Already reported as invalid operations param
+		return nil
+	}
+
+	stepErrors := []ParamError{}
+	for i, step := range operations {
+		prefix := fmt.Sprintf("operations[%d]", i)
+
+		if _, ok := OperationsMap[step.Name]; !ok {
+			stepErrors = append(stepErrors, ParamError{Param: prefix + ".operation", Value: step.Name, Message: "unsupported operation"})
+			continue
+		}
+
+		query, err := mapParamsValues(step.Params)
+		if err != nil {
+			stepErrors = append(stepErrors, ParamError{Param: prefix + ".params", Message: err.Error()})
+			continue
+		}
+
+		for _, err := range ValidateParams(query, step.Name) {
+			err.Param = prefix + "." + err.Param
+			stepErrors = append(stepErrors, err)
+		}
+	}
+	return stepErrors
+}
+
+func rangeMessage(r paramRange) string {
+	if r.Max == unbounded {
+		return fmt.Sprintf("must be greater than or equal to %g", r.Min)
+	}
+	return fmt.Sprintf("must be between %g and %g", r.Min, r.Max)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestValidateParams(t *testing.T) {
+	cases := []struct {
+		query     string
+		operation string
+		params    []string
+	}{
+		{"width=300&height=200&type=webp", "resize", []string{}},
+		{"operations=[]&type=webp&quality=60", "pipeline", []string{}},
+		{"operations=[]&colorspace=bw", "pipeline", []string{"colorspace"}},
+		{"width=50p&aspect=16:9&gravity=smart", "crop", []string{}},
+		{"width=abc", "resize", []string{"width"}},
+		{"width=-300", "resize", []string{"width"}},
+		{"width=300&quality=101", "resize", []string{"quality"}},
+		{"width=300&background=255,0", "resize", []string{"background"}},
+		{"width=300&background=255,0,256", "resize", []string{"background"}},
+		{"width=300&gravity=top", "resize", []string{"gravity"}},
+		{"width=300&type=bmp", "resize", []string{"type"}},
+		{"width=300&flip=yes", "resize", []string{"flip"}},
+		{"width=300&foo=bar", "resize", []string{"foo"}},
+		{"width=300&text=hello", "resize", []string{"text"}},
+		{"", "resize", []string{"width or height or aspect"}},
+		{"areawidth=300", "extract", []string{"areaheight or aspect"}},
+		{"sigma=1.5", "blur", []string{}},
+		{"rotate=-2.5", "rotate", []string{}},
+		{"colors=64", "palette", []string{"colors"}},
+		{"width=300&type=png&palette=true&palettecolors=64", "resize", []string{}},
+		{"width=300&palettecolors=300", "resize", []string{"palettecolors"}},
+		{"rotate=-361", "rotate", []string{"rotate"}},
+		{"widths=320,abc", "srcset", []string{"widths"}},
+		{"widths=320&types=webp,bmp", "srcset", []string{"types"}},
+		{"kind=avatar", "lqip", []string{"kind"}},
+		{"width=300&aspect=0:9", "resize", []string{"aspect"}},
+		{"width=2000p", "resize", []string{"width"}},
+		{"width=300&url=http://localhost/image.jpg&dpr=2", "resize", []string{}},
+		{"caption=foo&columns=4", "sheet", []string{}},
+		{"width=-300", "unknown", []string{"width"}},
+	}
+
+	for _, test := range cases {
+		query, _ := url.ParseQuery(test.query)
+		errs := ValidateParams(query, test.operation)
+
+		if len(errs) != len(test.params) {
+			t.Errorf("Invalid errors for %s: %#v", test.query, errs)
+			continue
+		}
+		for i, err := range errs {
+			if err.Param != test.params[i] {
+				t.Errorf("Invalid error param for %s: %s != %s", test.query, err.Param, test.params[i])
+			}
+			if err.Message == "" {
+				t.Errorf("Missing error message for %s", test.query)
+			}
+		}
+	}
+}
+
+func TestValidatePipelineParams(t *testing.T) {
+	query := url.Values{}
+	query.Set("operations", `[{"operation":"crop","params":{"width":-300}},{"operation":"info"},{"operation":"convert","params":{"type":"png"}}]`)
+
+	errs := ValidateParams(query, "pipeline")
+	if len(errs) != 2 {
+		t.Fatalf("Invalid errors: %#v", errs)
+	}
+	if errs[0].Param != "operations[0].width" || errs[0].Value != "-300" {
+		t.Errorf("Invalid error: %#v", errs[0])
+	}
+	if errs[1].Param != "operations[1].operation" || errs[1].Value != "info" {
+		t.Errorf("Invalid error: %#v", errs[1])
+	}
+
+	query.Set("operations", "[{")
+	errs = ValidateParams(query, "pipeline")
+	if len(errs) != 1 || errs[0].Param != "operations" {
+		t.Errorf("Invalid errors: %#v", errs)
+	}
+}
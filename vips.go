@@ -0,0 +1,292 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <string.h>
+#include <vips/vips.h>
+
+static int
+imaginary_image_pages(void *buf, size_t len)
+{
+	VipsImage *image;
+	int pages = 1;
+
+	if (!(image = vips_image_new_from_buffer(buf, len, "", NULL)))
+		return -1;
+
+	if (vips_image_get_typeof(image, "n-pages"))
+		vips_image_get_int(image, "n-pages", &pages);
+
+	g_object_unref(image);
+	return pages;
+}
+
+static int
+imaginary_has_operation(const char *name)
+{
+	return vips_type_find("VipsOperation", name) != 0;
+}
+
+static int
+imaginary_image_size(void *buf, size_t len, int *width, int *height)
+{
+	VipsImage *image;
+
+	if (!(image = vips_image_new_from_buffer(buf, len, "", NULL)))
+		return -1;
+
+	*width = vips_image_get_width(image);
+	*height = vips_image_get_height(image);
+
+	g_object_unref(image);
+	return 0;
+}
+
+static int
+imaginary_load_buffer(void *buf, size_t len, const char *options, void **out, size_t *out_len)
+{
+	VipsImage *image;
+	int err;
+
+	if (!(image = vips_image_new_from_buffer(buf, len, options, NULL)))
+		return -1;
+
+	err = vips_pngsave_buffer(image, out, out_len, "compression", 1, NULL);
+	g_object_unref(image);
+	return err;
+}
+
+static int
+imaginary_save_buffer(void *buf, size_t len, const char *format, void **out, size_t *out_len)
+{
+	VipsImage *image;
+	int err;
+
+	if (!(image = vips_image_new_from_buffer(buf, len, "", NULL)))
+		return -1;
+
+	err = vips_image_write_to_buffer(image, format, out, out_len, NULL);
+	g_object_unref(image);
+	return err;
+}
+
+static int
+imaginary_rotate(void *buf, size_t len, double angle, double *background, int alpha, int autorotate,
+	int width, int height, void **out, size_t *out_len)
+{
+	VipsImage *base = vips_image_new();
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 6);
+	VipsImage *image;
+	VipsArrayDouble *fill;
+	double ink[4];
+	int bands, err;
+
+	if (!(t[0] = vips_image_new_from_buffer(buf, len, "", NULL)))
+		goto error;
+	image = t[0];
+
+	if (autorotate) {
+		if (vips_autorot(image, &t[1], NULL))
+			goto error;
+		image = t[1];
+	}
+
+	if (vips_colourspace(image, &t[2], VIPS_INTERPRETATION_sRGB, NULL))
+		goto error;
+	image = t[2];
+
+	if (alpha && !vips_image_hasalpha(image)) {
+		if (vips_bandjoin_const1(image, &t[3], 255, NULL))
+			goto error;
+		image = t[3];
+	}
+
+	// Transparent corners for alpha images, otherwise the opaque background color
+	bands = VIPS_MIN(image->Bands, 4);
+	ink[0] = background[0];
+	ink[1] = background[1];
+	ink[2] = background[2];
+	ink[3] = alpha ? 0 : 255;
+
+	fill = vips_array_double_new(ink, bands);
+	err = vips_similarity(image, &t[4], "angle", angle, "background", fill, NULL);
+	vips_area_unref(VIPS_AREA(fill));
+	if (err)
+		goto error;
+	image = t[4];
+
+	if (width > 0 && height > 0 && width <= image->Xsize && height <= image->Ysize) {
+		if (vips_extract_area(image, &t[5], (image->Xsize - width) / 2, (image->Ysize - height) / 2, width, height, NULL))
+			goto error;
+		image = t[5];
+	}
+
+	err = vips_pngsave_buffer(image, out, out_len, "compression", 1, NULL);
+	g_object_unref(base);
+	return err;
+
+error:
+	g_object_unref(base);
+	return -1;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+// vipsImagePages returns the number of pages of the image, as reported by the libvips loader.
+// Single page images return 1.
+func vipsImagePages(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, ErrEmptyBody
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	input := vipsBuffer(buf)
+	defer C.free(input)
+
+	pages := C.imaginary_image_pages(input, C.size_t(len(buf)))
+	if pages < 0 {
+		return 0, vipsError()
+	}
+	return int(pages), nil
+}
+
+// vipsHasOperation reports whether the libvips installation provides the given operation,
+// such as the jxlsave_buffer saver.
+func vipsHasOperation(name string) bool {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	return C.imaginary_has_operation(cName) != 0
+}
+
+// vipsImageSize returns the image dimensions, as declared in its header, without decoding it.
+func vipsImageSize(buf []byte) (int, int, error) {
+	if len(buf) == 0 {
+		return 0, 0, ErrEmptyBody
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	input := vipsBuffer(buf)
+	defer C.free(input)
+
+	var width, height C.int
+	if C.imaginary_image_size(input, C.size_t(len(buf)), &width, &height) != 0 {
+		return 0, 0, vipsError()
+	}
+	return int(width), int(height), nil
+}
+
+// vipsLoadBuffer loads the image using the given libvips loader options,
+// such as "page=1,n=2", and returns it losslessly encoded as PNG.
+func vipsLoadBuffer(buf []byte, options string) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, ErrEmptyBody
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	input := vipsBuffer(buf)
+	defer C.free(input)
+
+	cOptions := C.CString(options)
+	defer C.free(unsafe.Pointer(cOptions))
+
+	var output unsafe.Pointer
+	var length C.size_t
+	if C.imaginary_load_buffer(input, C.size_t(len(buf)), cOptions, &output, &length) != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(output))
+
+	return C.GoBytes(output, C.int(length)), nil
+}
+
+// vipsSaveBuffer encodes the image using the given libvips format and saver options,
+// such as ".webp[Q=80,near_lossless=true]".
+func vipsSaveBuffer(buf []byte, format string) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, ErrEmptyBody
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	input := vipsBuffer(buf)
+	defer C.free(input)
+
+	cFormat := C.CString(format)
+	defer C.free(unsafe.Pointer(cFormat))
+
+	var output unsafe.Pointer
+	var length C.size_t
+	if C.imaginary_save_buffer(input, C.size_t(len(buf)), cFormat, &output, &length) != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(output))
+
+	return C.GoBytes(output, C.int(length)), nil
+}
+
+// vipsRotate rotates the image by any angle via libvips similarity transform, filling the exposed
+// corners with the background color, or transparency, and cropping the centered area, if defined.
+// The rotated image is returned losslessly encoded as PNG.
+func vipsRotate(buf []byte, o rotateOptions) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, ErrEmptyBody
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	input := vipsBuffer(buf)
+	defer C.free(input)
+
+	background := [3]C.double{}
+	for i := 0; i < len(o.Background) && i < 3; i++ {
+		background[i] = C.double(o.Background[i])
+	}
+
+	var output unsafe.Pointer
+	var length C.size_t
+	err := C.imaginary_rotate(input, C.size_t(len(buf)), C.double(o.Angle), &background[0], vipsBool(o.Alpha),
+		vipsBool(o.AutoRotate), C.int(o.Width), C.int(o.Height), &output, &length)
+	if err != 0 {
+		return nil, vipsError()
+	}
+	defer C.g_free(C.gpointer(output))
+
+	return C.GoBytes(output, C.int(length)), nil
+}
+
+func vipsBool(value bool) C.int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// vipsBuffer copies the buffer to C memory, since libvips may read it lazily
+func vipsBuffer(buf []byte) unsafe.Pointer {
+	input := C.malloc(C.size_t(len(buf)))
+	C.memcpy(input, unsafe.Pointer(&buf[0]), C.size_t(len(buf)))
+	return input
+}
+
+func vipsError() error {
+	message := C.GoString(C.vips_error_buffer())
+	C.vips_error_clear()
+	return errors.New(strings.TrimSpace(message))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+const watermarkImageField = "image"
+
+// watermarkOverlay scales the watermark image relative to the base image size, and returns
+// the PNG encoded overlay and its position. If tiling is enabled, the overlay covers the
+// whole base image with the watermark replicated across it.
+func watermarkOverlay(buf []byte, base bimg.ImageSize, o ImageOptions) (bimg.WatermarkImage, error) {
+	size, err := bimg.Size(buf)
+	if err != nil {
+		return bimg.WatermarkImage{}, err
+	}
+
+	width, height := watermarkSize(size, base, o.Scale)
+	overlay, err := Process(buf, bimg.Options{
+		Width:   width,
+		Height:  height,
+		Force:   true,
+		Enlarge: true,
+		Type:    bimg.PNG,
+	})
+	if err != nil {
+		return bimg.WatermarkImage{}, err
+	}
+
+	watermark := bimg.WatermarkImage{Buf: overlay.Body, Opacity: o.Opacity}
+	if o.Tile {
+		watermark.Buf, err = tileWatermark(overlay.Body, base, o.Margin)
+		return watermark, err
+	}
+
+	watermark.Left, watermark.Top = watermarkPosition(width, height, base, o)
+	return watermark, nil
+}
+
+// watermarkSize returns the watermark size scaled by the given factor relative to the
+// base image width, preserving its aspect ratio and always fitting the base image.
+func watermarkSize(size, base bimg.ImageSize, scale float64) (int, int) {
+	factor := 1.0
+	if scale > 0 {
+		factor = scale * float64(base.Width) / float64(size.Width)
+	}
+
+	factor = math.Min(factor, float64(base.Width)/float64(size.Width))
+	factor = math.Min(factor, float64(base.Height)/float64(size.Height))
+
+	width := int(math.Max(1, math.Floor(float64(size.Width)*factor+0.5)))
+	height := int(math.Max(1, math.Floor(float64(size.Height)*factor+0.5)))
+	return width, height
+}
+
+// watermarkPosition returns the watermark top left corner. Explicit top and left params
+// take precedence, otherwise the watermark is placed based on the gravity and margin params.
+func watermarkPosition(width, height int, base bimg.ImageSize, o ImageOptions) (int, int) {
+	maxLeft, maxTop := base.Width-width, base.Height-height
+	if o.Top > 0 || o.Left > 0 {
+		return clamp(o.Left, 0, maxLeft), clamp(o.Top, 0, maxTop)
+	}
+
+	left, top := maxLeft/2, maxTop/2
+	switch o.Gravity {
+	case bimg.GravityNorth:
+		top = o.Margin
+	case bimg.GravitySouth:
+		top = maxTop - o.Margin
+	case bimg.GravityWest:
+		left = o.Margin
+	case bimg.GravityEast:
+		left = maxLeft - o.Margin
+	}
+
+	return clamp(left, 0, maxLeft), clamp(top, 0, maxTop)
+}
+
+// tileWatermark replicates the watermark image across a transparent canvas
+// with the base image size, using the given margin as spacing between tiles.
+func tileWatermark(buf []byte, base bimg.ImageSize, margin int) ([]byte, error) {
+	tile, err := png.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := tile.Bounds()
+	canvas := image.NewNRGBA(image.Rect(0, 0, base.Width, base.Height))
+	for y := 0; y < base.Height; y += bounds.Dy() + margin {
+		for x := 0; x < base.Width; x += bounds.Dx() + margin {
+			rect := image.Rect(x, y, x+bounds.Dx(), y+bounds.Dy())
+			draw.Draw(canvas, rect, tile, bounds.Min, draw.Src)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	if err := png.Encode(out, canvas); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+func TestWatermarkSize(t *testing.T) {
+	base := bimg.ImageSize{Width: 1000, Height: 500}
+	cases := []struct {
+		size     bimg.ImageSize
+		scale    float64
+		expected bimg.ImageSize
+	}{
+		{bimg.ImageSize{Width: 100, Height: 50}, 0, bimg.ImageSize{Width: 100, Height: 50}},
+		{bimg.ImageSize{Width: 100, Height: 50}, 0.5, bimg.ImageSize{Width: 500, Height: 250}},
+		{bimg.ImageSize{Width: 100, Height: 50}, 2, bimg.ImageSize{Width: 1000, Height: 500}},
+		{bimg.ImageSize{Width: 2000, Height: 2000}, 0, bimg.ImageSize{Width: 500, Height: 500}},
+	}
+
+	for _, test := range cases {
+		width, height := watermarkSize(test.size, base, test.scale)
+		if width != test.expected.Width || height != test.expected.Height {
+			t.Errorf("Invalid watermark size: %dx%d != %dx%d", width, height, test.expected.Width, test.expected.Height)
+		}
+	}
+}
+
+func TestWatermarkPosition(t *testing.T) {
+	base := bimg.ImageSize{Width: 1000, Height: 500}
+	cases := []struct {
+		opts ImageOptions
+		left int
+		top  int
+	}{
+		{ImageOptions{}, 450, 225},
+		{ImageOptions{Gravity: bimg.GravityNorth, Margin: 10}, 450, 10},
+		{ImageOptions{Gravity: bimg.GravitySouth, Margin: 10}, 450, 440},
+		{ImageOptions{Gravity: bimg.GravityEast, Margin: 10}, 890, 225},
+		{ImageOptions{Gravity: bimg.GravityWest}, 0, 225},
+		{ImageOptions{Top: 20, Left: 30}, 30, 20},
+		{ImageOptions{Top: 2000, Left: 3000}, 900, 450},
+	}
+
+	for _, test := range cases {
+		left, top := watermarkPosition(100, 50, base, test.opts)
+		if left != test.left || top != test.top {
+			t.Errorf("Invalid watermark position: %d,%d != %d,%d", left, top, test.left, test.top)
+		}
+	}
+}
+
+func TestTileWatermark(t *testing.T) {
+	tile := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			tile.Set(x, y, color.NRGBA{255, 0, 0, 255})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	png.Encode(buf, tile)
+
+	out, err := tileWatermark(buf.Bytes(), bimg.ImageSize{Width: 35, Height: 20}, 5)
+	if err != nil {
+		t.Fatalf("Cannot tile watermark: %s", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 35 || img.Bounds().Dy() != 20 {
+		t.Fatalf("Invalid tiled image size: %s", img.Bounds())
+	}
+
+	if _, _, _, a := img.At(32, 2).RGBA(); a == 0 {
+		t.Error("Expected watermark tile at 32,2")
+	}
+	if _, _, _, a := img.At(12, 2).RGBA(); a != 0 {
+		t.Error("Expected transparent margin at 12,2")
+	}
+}